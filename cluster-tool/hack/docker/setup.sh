@@ -6,13 +6,13 @@ set -o pipefail
 
 IMG=cluster-tool
 TAG=v1
-RESTIC_VERSION=${RESTIC_VERSION:-0.9.4}
+RESTIC_VERSION=${RESTIC_VERSION:-0.9.6}
 DOCKER_REGISTRY=${DOCKER_REGISTRY:-appscodeci}
 REPO_ROOT=$GOPATH/src/github.com/appscodelabs/actions
 
 build() {
   pushd $REPO_ROOT
-  docker build -t $DOCKER_REGISTRY/$IMG:$TAG . -f ./cluster-tool/hack/docker/Dockerfile --build-arg RESTIC_VERSION=$RESTIC_VERSION
+  docker build -t $DOCKER_REGISTRY/$IMG:$TAG . -f ./cluster-tool/hack/docker/Dockerfile --build-arg RESTIC_VERSION=$RESTIC_VERSION --build-arg VERSION=$TAG
   popd
 }
 
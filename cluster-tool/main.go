@@ -6,7 +6,17 @@ import (
 
 	"github.com/appscode/go/log"
 	logs "github.com/appscode/go/log/golog"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
 	"github.com/appscodelabs/actions/cluster-tool/pkg/cmds"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/diff"
+)
+
+const (
+	// ExitCodeDrift is returned when the live cluster differs from a snapshot
+	ExitCodeDrift = 1
+	// ExitCodePartial is returned when a best-effort backup skipped some resources.
+	// Failures exit with code 255.
+	ExitCodePartial = 2
 )
 
 func main() {
@@ -18,8 +28,17 @@ func main() {
 	}
 
 	if err := cmds.NewRootCmd().Execute(); err != nil {
+		if backup.IsPartialBackupError(err) {
+			// the backup has been taken, but some resources were skipped
+			log.Errorln("Backup is partial:", err)
+			logs.FlushLogs()
+			os.Exit(ExitCodePartial)
+		}
+		if diff.IsDriftError(err) {
+			logs.FlushLogs()
+			os.Exit(ExitCodeDrift)
+		}
 		log.Fatalln("Failed to execute root command:", err)
 	}
-	log.Infoln("Backup Successful")
 	os.Exit(0)
 }
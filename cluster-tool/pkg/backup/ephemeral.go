@@ -0,0 +1,87 @@
+package backup
+
+import (
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// EphemeralResource is a resource that is not backed up unless
+// Options.IncludeEphemeral is set or the include list of the filter names it.
+// Its objects change all the time, are maintained by the cluster or are not
+// stored at all.
+type EphemeralResource struct {
+	Group    string
+	Resource string
+	Reason   string
+}
+
+func (e EphemeralResource) String() string {
+	if e.Group == core.GroupName {
+		return e.Resource
+	}
+	return e.Resource + "." + e.Group
+}
+
+// EphemeralResources are left out of backups by default
+var EphemeralResources = []EphemeralResource{
+	{Group: core.GroupName, Resource: "events", Reason: "recorded on every state change and expired by the api server after an hour"},
+	{Group: "events.k8s.io", Resource: "events", Reason: "the same Events, served by another api group"},
+	{Group: "coordination.k8s.io", Resource: "leases", Reason: "leader election and node heartbeats, renewed every few seconds"},
+	{Group: core.GroupName, Resource: "endpoints", Reason: "maintained by the endpoints controller from the Service selectors. Endpoints of Services without a selector are kept."},
+	{Group: "discovery.k8s.io", Resource: "endpointslices", Reason: "maintained by the endpoint slice controller from the Service selectors"},
+	{Group: core.GroupName, Resource: "componentstatuses", Reason: "not stored, the health of the control plane is probed on every request"},
+	{Group: "metrics.k8s.io", Resource: "nodes", Reason: "not stored, resource usage served by the metrics server"},
+	{Group: "metrics.k8s.io", Resource: "pods", Reason: "not stored, resource usage served by the metrics server"},
+}
+
+const (
+	// EphemeralSecretsNamespace holds the ServiceAccount token Secrets left out by default
+	EphemeralSecretsNamespace = "kube-system"
+	// EphemeralSecretsReason tells why the token Secrets of EphemeralSecretsNamespace are left out
+	EphemeralSecretsReason = "ServiceAccount tokens of the controllers, created by the token controller and recreated when missing"
+)
+
+func isEphemeralResource(gv schema.GroupVersion, r metav1.APIResource) bool {
+	for _, e := range EphemeralResources {
+		if gv.Group == e.Group && r.Name == e.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+// excludesEphemeral reports whether r is left out as ephemeral when the
+// ephemeral resources are excluded. A resource named by the include list of
+// filter is kept, glob patterns do not count.
+func excludesEphemeral(filter ResourceFilter, gv schema.GroupVersion, r metav1.APIResource) bool {
+	return isEphemeralResource(gv, r) && !filter.namesResource(gv, r)
+}
+
+func isEndpoints(gv schema.GroupVersion, r metav1.APIResource) bool {
+	return gv.Group == core.GroupName && r.Name == "endpoints"
+}
+
+func isEphemeralSecret(item map[string]interface{}) bool {
+	t, _ := item["type"].(string)
+	return t == string(core.SecretTypeServiceAccountToken) && getNamespace(item["metadata"]) == EphemeralSecretsNamespace
+}
+
+// withoutEphemeralSecrets returns the tasks listing the Secrets of t except
+// the ephemeral ones, which are left out by the field selectors of the list
+// requests
+func withoutEphemeralSecrets(t resourceTask) []resourceTask {
+	tokens := "type!=" + string(core.SecretTypeServiceAccountToken)
+	switch t.namespace {
+	case "":
+		others := t
+		others.fieldSelector = "metadata.namespace!=" + EphemeralSecretsNamespace
+		system := t
+		system.namespace = EphemeralSecretsNamespace
+		system.fieldSelector = tokens
+		return []resourceTask{others, system}
+	case EphemeralSecretsNamespace:
+		t.fieldSelector = tokens
+	}
+	return []resourceTask{t}
+}
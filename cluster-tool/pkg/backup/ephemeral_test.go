@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestExcludesEphemeral(t *testing.T) {
+	coreV1 := schema.GroupVersion{Version: "v1"}
+	eventsV1 := schema.GroupVersion{Group: "events.k8s.io", Version: "v1beta1"}
+	events := metav1.APIResource{Name: "events", Kind: "Event", Namespaced: true}
+	cases := []struct {
+		name    string
+		include []string
+		gv      schema.GroupVersion
+		want    bool
+	}{
+		{"no include list", nil, coreV1, true},
+		{"other resource included", []string{"deployments.apps"}, coreV1, true},
+		{"included by a pattern", []string{"*"}, coreV1, true},
+		{"included by name", []string{"events"}, coreV1, false},
+		{"included by name in another group", []string{"events"}, eventsV1, false},
+		{"included by qualified name", []string{"events.events.k8s.io"}, eventsV1, false},
+		{"other group included by qualified name", []string{"events.events.k8s.io"}, coreV1, true},
+	}
+	for _, c := range cases {
+		if got := excludesEphemeral(ResourceFilter{IncludeResources: c.include}, c.gv, events); got != c.want {
+			t.Errorf("%s: excludesEphemeral() = %v, want %v", c.name, got, c.want)
+		}
+	}
+	configMaps := metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true}
+	if excludesEphemeral(ResourceFilter{}, coreV1, configMaps) {
+		t.Error("configmaps are ephemeral")
+	}
+}
+
+func TestWithoutEphemeralSecrets(t *testing.T) {
+	secrets := resourceTask{
+		gv:       schema.GroupVersion{Version: "v1"},
+		resource: metav1.APIResource{Name: "secrets", Kind: "Secret", Namespaced: true},
+	}
+	inNamespace := func(ns, fieldSelector string) resourceTask {
+		t := secrets
+		t.namespace = ns
+		t.fieldSelector = fieldSelector
+		return t
+	}
+	cases := []struct {
+		namespace string
+		want      []resourceTask
+	}{
+		{"", []resourceTask{
+			inNamespace("", "metadata.namespace!=kube-system"),
+			inNamespace("kube-system", "type!=kubernetes.io/service-account-token"),
+		}},
+		{"kube-system", []resourceTask{inNamespace("kube-system", "type!=kubernetes.io/service-account-token")}},
+		{"payments", []resourceTask{inNamespace("payments", "")}},
+	}
+	for _, c := range cases {
+		if got := withoutEphemeralSecrets(inNamespace(c.namespace, "")); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("withoutEphemeralSecrets(%q) = %+v, want %+v", c.namespace, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package backup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type pathElemType int
+
+const (
+	elemField pathElemType = iota
+	elemIndex
+	elemWildcard
+	elemFilter
+)
+
+// pathElem is a step of a fieldPath
+type pathElem struct {
+	typ pathElemType
+	// key of a field, or the field compared by a filter
+	key   string
+	index int
+	// value a filter compares the field with
+	value string
+}
+
+// fieldPath selects fields of an object with a subset of JSONPath: fields as
+// .name or ['name'], list items as [0], every item or field as [*] and the
+// list items having a field equal to a string as [?(@.name=="value")].
+type fieldPath struct {
+	text  string
+	elems []pathElem
+}
+
+func parseFieldPath(text string) (*fieldPath, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(text), "$")
+	if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+	p := &fieldPath{text: text}
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid path %q: %s", text, reason)
+	}
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, invalid("empty field name")
+			}
+			p.elems = append(p.elems, pathElem{typ: elemField, key: s[:end]})
+			s = s[end:]
+		case '[':
+			end := closingBracket(s)
+			if end < 0 {
+				return nil, invalid("missing ]")
+			}
+			elem, err := parseBracket(s[1:end])
+			if err != nil {
+				return nil, invalid(err.Error())
+			}
+			p.elems = append(p.elems, elem)
+			s = s[end+1:]
+		default:
+			return nil, invalid("expected . or [")
+		}
+	}
+	if len(p.elems) == 0 {
+		return nil, invalid("empty path")
+	}
+	return p, nil
+}
+
+// closingBracket returns the index of the ] closing the [ s starts with,
+// skipping quoted strings
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote != 0:
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseBracket(s string) (pathElem, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "*":
+		return pathElem{typ: elemWildcard}, nil
+	case isQuoted(s):
+		return pathElem{typ: elemField, key: s[1 : len(s)-1]}, nil
+	case strings.HasPrefix(s, "?(@.") && strings.HasSuffix(s, ")"):
+		parts := strings.SplitN(s[len("?(@."):len(s)-1], "==", 2)
+		value := strings.TrimSpace(parts[len(parts)-1])
+		if len(parts) != 2 || !isQuoted(value) {
+			return pathElem{}, fmt.Errorf("filters must compare a field with a string, i.e. [?(@.name==\"x\")]")
+		}
+		return pathElem{typ: elemFilter, key: strings.TrimSpace(parts[0]), value: value[1 : len(value)-1]}, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return pathElem{}, fmt.Errorf("unsupported selector [%s]", s)
+	}
+	return pathElem{typ: elemIndex, index: i}, nil
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]
+}
+
+func (p *fieldPath) String() string {
+	return p.text
+}
+
+// remove deletes the selected fields and list items from obj
+func (p *fieldPath) remove(obj map[string]interface{}) {
+	updatePath(obj, p.elems, false, func(interface{}) (interface{}, bool) {
+		return nil, false
+	})
+}
+
+// set sets the selected fields to value. Missing fields are created, unless
+// they are selected by list items or wildcards.
+func (p *fieldPath) set(obj map[string]interface{}, value interface{}) {
+	updatePath(obj, p.elems, true, func(interface{}) (interface{}, bool) {
+		return copyJSONValue(value), true
+	})
+}
+
+// updatePath replaces the values path selects in v by the result of fn, or
+// deletes them if fn returns false. It returns the updated v.
+func updatePath(v interface{}, path []pathElem, create bool, fn func(old interface{}) (interface{}, bool)) interface{} {
+	elem, rest := path[0], path[1:]
+	update := func(old interface{}) (interface{}, bool) {
+		if len(rest) == 0 {
+			return fn(old)
+		}
+		return updatePath(old, rest, create, fn), true
+	}
+
+	switch elem.typ {
+	case elemField:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if v != nil || !create {
+				return v
+			}
+			m = map[string]interface{}{}
+		}
+		old, exists := m[elem.key]
+		// only fields are created, there is no list item or wildcard to select in a missing field
+		if !exists && (!create || len(rest) > 0 && rest[0].typ != elemField) {
+			return m
+		}
+		if nv, keep := update(old); keep {
+			m[elem.key] = nv
+		} else {
+			delete(m, elem.key)
+		}
+		return m
+	case elemWildcard:
+		if m, ok := v.(map[string]interface{}); ok {
+			for k, old := range m {
+				if nv, keep := update(old); keep {
+					m[k] = nv
+				} else {
+					delete(m, k)
+				}
+			}
+			return m
+		}
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		return v
+	}
+	result := make([]interface{}, 0, len(list))
+	for i, item := range list {
+		if !elem.selects(i, item) {
+			result = append(result, item)
+			continue
+		}
+		if nv, keep := update(item); keep {
+			result = append(result, nv)
+		}
+	}
+	return result
+}
+
+func (e pathElem) selects(i int, item interface{}) bool {
+	switch e.typ {
+	case elemIndex:
+		return i == e.index
+	case elemWildcard:
+		return true
+	case elemFilter:
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		value, ok := m[e.key]
+		return ok && fmt.Sprint(value) == e.value
+	}
+	return false
+}
+
+// copyJSONValue deep copies a value decoded from JSON or YAML
+func copyJSONValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[k] = copyJSONValue(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(x))
+		for i, v := range x {
+			l[i] = copyJSONValue(v)
+		}
+		return l
+	}
+	return v
+}
@@ -0,0 +1,310 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	cases := []struct {
+		text    string
+		want    []pathElem
+		wantErr bool
+	}{
+		{text: ".metadata.uid", want: []pathElem{{typ: elemField, key: "metadata"}, {typ: elemField, key: "uid"}}},
+		{text: "metadata.uid", want: []pathElem{{typ: elemField, key: "metadata"}, {typ: elemField, key: "uid"}}},
+		{text: "$.status", want: []pathElem{{typ: elemField, key: "status"}}},
+		{
+			text: ".metadata.annotations['deployment.kubernetes.io/revision']",
+			want: []pathElem{{typ: elemField, key: "metadata"}, {typ: elemField, key: "annotations"}, {typ: elemField, key: "deployment.kubernetes.io/revision"}},
+		},
+		{
+			text: `.metadata.labels["a]b"]`,
+			want: []pathElem{{typ: elemField, key: "metadata"}, {typ: elemField, key: "labels"}, {typ: elemField, key: "a]b"}},
+		},
+		{text: ".spec.containers[0].image", want: []pathElem{{typ: elemField, key: "spec"}, {typ: elemField, key: "containers"}, {typ: elemIndex, index: 0}, {typ: elemField, key: "image"}}},
+		{text: ".spec.containers[*]", want: []pathElem{{typ: elemField, key: "spec"}, {typ: elemField, key: "containers"}, {typ: elemWildcard}}},
+		{
+			text: `.spec.containers[?(@.name == "api")].image`,
+			want: []pathElem{{typ: elemField, key: "spec"}, {typ: elemField, key: "containers"}, {typ: elemFilter, key: "name", value: "api"}, {typ: elemField, key: "image"}},
+		},
+		{text: "", wantErr: true},
+		{text: "$", wantErr: true},
+		{text: ".spec..containers", wantErr: true},
+		{text: ".spec.containers[0", wantErr: true},
+		{text: ".spec.containers[-1]", wantErr: true},
+		{text: ".spec.containers[?(@.name==api)]", wantErr: true},
+		{text: ".spec.containers[1:2]", wantErr: true},
+	}
+	for _, c := range cases {
+		p, err := parseFieldPath(c.text)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseFieldPath(%q) = %+v, expected an error", c.text, p.elems)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFieldPath(%q) failed: %v", c.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.elems, c.want) {
+			t.Errorf("parseFieldPath(%q) = %+v, want %+v", c.text, p.elems, c.want)
+		}
+	}
+}
+
+const pathPod = `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+`
+
+func TestFieldPathRemove(t *testing.T) {
+	cases := []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "field",
+			path: ".metadata.annotations.a",
+			want: `
+metadata:
+  name: api
+  annotations: {b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name: "missing field",
+			path: ".spec.nodeName",
+			want: pathPod,
+		},
+		{
+			name: "field of a missing parent",
+			path: ".status.phase",
+			want: pathPod,
+		},
+		{
+			name: "index",
+			path: ".spec.containers[1]",
+			want: `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name: "index out of range",
+			path: ".spec.containers[5]",
+			want: pathPod,
+		},
+		{
+			name: "wildcard over a list",
+			path: ".spec.containers[*].terminationMessagePath",
+			want: `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0"}
+  - {name: proxy, image: "proxy:2.1"}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name: "wildcard over a map",
+			path: ".metadata.annotations[*]",
+			want: `
+metadata:
+  name: api
+  annotations: {}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name: "filter",
+			path: `.spec.containers[?(@.name=="proxy")].terminationMessagePath`,
+			want: `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  - {name: proxy, image: "proxy:2.1"}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name: "filter removing the items",
+			path: `.spec.containers[?(@.name=="api")]`,
+			want: `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name: "list selector on a map",
+			path: ".metadata[0]",
+			want: pathPod,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := parseFieldPath(c.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj := decodeYAML(t, pathPod)
+			p.remove(obj)
+			if want := decodeYAML(t, c.want); !reflect.DeepEqual(obj, want) {
+				t.Errorf("got %v, want %v", obj, want)
+			}
+		})
+	}
+}
+
+func TestFieldPathSet(t *testing.T) {
+	cases := []struct {
+		name  string
+		path  string
+		value interface{}
+		want  string
+	}{
+		{
+			name:  "replace a field",
+			path:  ".metadata.name",
+			value: "web",
+			want: `
+metadata:
+  name: web
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name:  "create missing fields",
+			path:  ".spec.securityContext.runAsNonRoot",
+			value: true,
+			want: `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+  securityContext: {runAsNonRoot: true}
+`,
+		},
+		{
+			name:  "create a field in the selected items",
+			path:  `.spec.containers[?(@.name=="api")].imagePullPolicy`,
+			value: "Always",
+			want: `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log, imagePullPolicy: Always}
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - name: data
+`,
+		},
+		{
+			name:  "set every item",
+			path:  ".spec.volumes[*].emptyDir",
+			value: map[string]interface{}{},
+			want: `
+metadata:
+  name: api
+  annotations: {a: "1", b: "2"}
+spec:
+  containers:
+  - {name: api, image: "api:1.0", terminationMessagePath: /dev/termination-log}
+  - {name: proxy, image: "proxy:2.1", terminationMessagePath: /dev/termination-log}
+  volumes:
+  - {name: data, emptyDir: {}}
+`,
+		},
+		{
+			name:  "no list is created for an index",
+			path:  ".spec.initContainers[0].image",
+			value: "init:1.0",
+			want:  pathPod,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := parseFieldPath(c.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj := decodeYAML(t, pathPod)
+			p.set(obj, c.value)
+			if want := decodeYAML(t, c.want); !reflect.DeepEqual(obj, want) {
+				t.Errorf("got %v, want %v", obj, want)
+			}
+		})
+	}
+}
+
+func TestFieldPathSetCopiesValue(t *testing.T) {
+	p, err := parseFieldPath(".spec.volumes[*].emptyDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := decodeYAML(t, `{spec: {volumes: [{name: a}, {name: b}]}}`)
+	value := map[string]interface{}{"medium": "Memory"}
+	p.set(obj, value)
+	value["medium"] = "Disk"
+
+	volumes := obj["spec"].(map[string]interface{})["volumes"].([]interface{})
+	first := volumes[0].(map[string]interface{})["emptyDir"].(map[string]interface{})
+	second := volumes[1].(map[string]interface{})["emptyDir"].(map[string]interface{})
+	first["sizeLimit"] = "1Gi"
+	if first["medium"] != "Memory" || len(second) != 1 {
+		t.Errorf("set values share memory: %v, %v", first, second)
+	}
+}
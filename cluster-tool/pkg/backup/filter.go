@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"path"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResourceFilter selects which namespaces and resources are dumped. Every entry
+// may be a glob pattern as understood by path.Match. Resources are matched by
+// their plural name (i.e. "deployments") and by their name qualified with the
+// api group (i.e. "deployments.apps", "*.cert-manager.io"). Exclusions take
+// precedence over inclusions and an empty include list includes everything.
+type ResourceFilter struct {
+	IncludeNamespaces []string `json:"includeNamespaces,omitempty"`
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	IncludeResources  []string `json:"includeResources,omitempty"`
+	ExcludeResources  []string `json:"excludeResources,omitempty"`
+}
+
+// NamespaceFiltered reports whether only a subset of namespaces will be dumped.
+func (f ResourceFilter) NamespaceFiltered() bool {
+	return len(f.IncludeNamespaces) > 0 || len(f.ExcludeNamespaces) > 0
+}
+
+// IncludesClusterScoped reports whether cluster scoped resources are dumped.
+// They do not belong to any namespace, so only an include list leaves them out.
+func (f ResourceFilter) IncludesClusterScoped() bool {
+	return len(f.IncludeNamespaces) == 0
+}
+
+func (f ResourceFilter) IncludesNamespace(ns string) bool {
+	return includes(f.IncludeNamespaces, f.ExcludeNamespaces, ns)
+}
+
+func (f ResourceFilter) IncludesResource(gv schema.GroupVersion, r metav1.APIResource) bool {
+	return includes(f.IncludeResources, f.ExcludeResources, resourceNames(gv, r)...)
+}
+
+// namesResource reports whether the include list names r, not only matches it
+// by a glob pattern
+func (f ResourceFilter) namesResource(gv schema.GroupVersion, r metav1.APIResource) bool {
+	for _, p := range f.IncludeResources {
+		for _, name := range resourceNames(gv, r) {
+			if p == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func resourceNames(gv schema.GroupVersion, r metav1.APIResource) []string {
+	names := []string{r.Name}
+	if gv.Group != core.GroupName {
+		names = append(names, r.Name+"."+gv.Group)
+	}
+	return names
+}
+
+// literalNamespaces returns the included namespaces if all of them are plain
+// names, so that they can be used without listing the namespaces first.
+func (f ResourceFilter) literalNamespaces() ([]string, bool) {
+	if len(f.IncludeNamespaces) == 0 {
+		return nil, false
+	}
+	var namespaces []string
+	for _, ns := range f.IncludeNamespaces {
+		if hasMeta(ns) {
+			return nil, false
+		}
+		if f.IncludesNamespace(ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces, true
+}
+
+func includes(include, exclude []string, names ...string) bool {
+	if matchesAny(exclude, names) {
+		return false
+	}
+	return len(include) == 0 || matchesAny(include, names)
+}
+
+func matchesAny(patterns, names []string) bool {
+	for _, p := range patterns {
+		for _, name := range names {
+			if ok, err := path.Match(p, name); err == nil && ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIncludesNamespace(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter ResourceFilter
+		ns     string
+		want   bool
+	}{
+		{"empty filter", ResourceFilter{}, "payments", true},
+		{"included", ResourceFilter{IncludeNamespaces: []string{"pay*"}}, "payments", true},
+		{"not included", ResourceFilter{IncludeNamespaces: []string{"web"}}, "payments", false},
+		{"excluded", ResourceFilter{ExcludeNamespaces: []string{"kube-*"}}, "kube-system", false},
+		{"not excluded", ResourceFilter{ExcludeNamespaces: []string{"kube-*"}}, "payments", true},
+		{"exclusion wins", ResourceFilter{IncludeNamespaces: []string{"*"}, ExcludeNamespaces: []string{"payments"}}, "payments", false},
+	}
+	for _, c := range cases {
+		if got := c.filter.IncludesNamespace(c.ns); got != c.want {
+			t.Errorf("%s: IncludesNamespace(%q) = %v, want %v", c.name, c.ns, got, c.want)
+		}
+	}
+}
+
+func TestIncludesResource(t *testing.T) {
+	coreV1 := schema.GroupVersion{Version: "v1"}
+	appsV1 := schema.GroupVersion{Group: "apps", Version: "v1"}
+	certs := schema.GroupVersion{Group: "cert-manager.io", Version: "v1alpha2"}
+	configMaps := metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true}
+	deployments := metav1.APIResource{Name: "deployments", Kind: "Deployment", Namespaced: true}
+	certificates := metav1.APIResource{Name: "certificates", Kind: "Certificate", Namespaced: true}
+
+	cases := []struct {
+		name   string
+		filter ResourceFilter
+		gv     schema.GroupVersion
+		r      metav1.APIResource
+		want   bool
+	}{
+		{"empty filter", ResourceFilter{}, coreV1, configMaps, true},
+		{"plural name", ResourceFilter{IncludeResources: []string{"deployments"}}, appsV1, deployments, true},
+		{"qualified name", ResourceFilter{IncludeResources: []string{"deployments.apps"}}, appsV1, deployments, true},
+		{"qualified name of the core group", ResourceFilter{IncludeResources: []string{"configmaps."}}, coreV1, configMaps, false},
+		{"group pattern", ResourceFilter{IncludeResources: []string{"*.cert-manager.io"}}, certs, certificates, true},
+		{"group pattern of another group", ResourceFilter{IncludeResources: []string{"*.cert-manager.io"}}, appsV1, deployments, false},
+		{"excluded", ResourceFilter{ExcludeResources: []string{"configmaps"}}, coreV1, configMaps, false},
+		{"exclusion wins", ResourceFilter{IncludeResources: []string{"*"}, ExcludeResources: []string{"*.cert-manager.io"}}, certs, certificates, false},
+		{"exclusion wins over a name", ResourceFilter{IncludeResources: []string{"deployments.apps"}, ExcludeResources: []string{"deploy*"}}, appsV1, deployments, false},
+		{"other resource excluded", ResourceFilter{ExcludeResources: []string{"configmaps"}}, appsV1, deployments, true},
+	}
+	for _, c := range cases {
+		if got := c.filter.IncludesResource(c.gv, c.r); got != c.want {
+			t.Errorf("%s: IncludesResource(%s, %s) = %v, want %v", c.name, c.gv, c.r.Name, got, c.want)
+		}
+	}
+}
+
+func TestIncludesClusterScoped(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter ResourceFilter
+		want   bool
+	}{
+		{"empty filter", ResourceFilter{}, true},
+		{"namespaces included", ResourceFilter{IncludeNamespaces: []string{"payments"}}, false},
+		{"namespaces excluded only", ResourceFilter{ExcludeNamespaces: []string{"kube-*"}}, true},
+		{"resources filtered only", ResourceFilter{IncludeResources: []string{"clusterroles.rbac.authorization.k8s.io"}}, true},
+	}
+	for _, c := range cases {
+		if got := c.filter.IncludesClusterScoped(); got != c.want {
+			t.Errorf("%s: IncludesClusterScoped() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLiteralNamespaces(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter ResourceFilter
+		want   []string
+		wantOK bool
+	}{
+		{"no include list", ResourceFilter{ExcludeNamespaces: []string{"kube-system"}}, nil, false},
+		{"plain names", ResourceFilter{IncludeNamespaces: []string{"payments", "web"}}, []string{"payments", "web"}, true},
+		{"plain names with exclusions", ResourceFilter{IncludeNamespaces: []string{"payments", "web"}, ExcludeNamespaces: []string{"w*"}}, []string{"payments"}, true},
+		{"star", ResourceFilter{IncludeNamespaces: []string{"payments", "web-*"}}, nil, false},
+		{"question mark", ResourceFilter{IncludeNamespaces: []string{"web-?"}}, nil, false},
+		{"character class", ResourceFilter{IncludeNamespaces: []string{"web-[ab]"}}, nil, false},
+		{"escape", ResourceFilter{IncludeNamespaces: []string{`web\-a`}}, nil, false},
+	}
+	for _, c := range cases {
+		got, ok := c.filter.literalNamespaces()
+		if ok != c.wantOK || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: literalNamespaces() = %v, %v, want %v, %v", c.name, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestNamesResource(t *testing.T) {
+	coreV1 := schema.GroupVersion{Version: "v1"}
+	eventsV1 := schema.GroupVersion{Group: "events.k8s.io", Version: "v1beta1"}
+	events := metav1.APIResource{Name: "events", Kind: "Event", Namespaced: true}
+	cases := []struct {
+		name    string
+		include []string
+		gv      schema.GroupVersion
+		want    bool
+	}{
+		{"no include list", nil, coreV1, false},
+		{"plural name", []string{"events"}, coreV1, true},
+		{"plural name in another group", []string{"events"}, eventsV1, true},
+		{"qualified name", []string{"events.events.k8s.io"}, eventsV1, true},
+		{"qualified name of another group", []string{"events.events.k8s.io"}, coreV1, false},
+		{"pattern", []string{"event*"}, coreV1, false},
+		{"star", []string{"*"}, coreV1, false},
+	}
+	for _, c := range cases {
+		f := ResourceFilter{IncludeResources: c.include, ExcludeResources: []string{"pods"}}
+		if got := f.namesResource(c.gv, events); got != c.want {
+			t.Errorf("%s: namesResource(%s, events) = %v, want %v", c.name, c.gv, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package backup
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is an operation of a JSON patch (RFC 6902)
+type PatchOperation struct {
+	// Op is one of add, remove, replace, move, copy or test
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type patchTestFailedError struct {
+	path string
+}
+
+func (e *patchTestFailedError) Error() string {
+	return fmt.Sprintf("test of %s failed", e.path)
+}
+
+func validatePatch(patch []PatchOperation) error {
+	for _, op := range patch {
+		switch op.Op {
+		case "add", "remove", "replace", "test":
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown patch operation %q", op.Op)
+		}
+		if _, err := parsePointer(op.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPatch applies a JSON patch to a copy of doc. As defined by RFC 6902, the
+// patch is applied as a whole or not at all: it returns an error if any
+// operation fails, including a test operation.
+func applyPatch(doc map[string]interface{}, patch []PatchOperation) (map[string]interface{}, error) {
+	var out interface{} = copyJSONValue(doc)
+	for _, op := range patch {
+		path, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			out, err = addValue(out, path, copyJSONValue(op.Value))
+		case "remove":
+			out, err = removeValue(out, path)
+		case "replace":
+			if out, err = removeValue(out, path); err == nil {
+				out, err = addValue(out, path, copyJSONValue(op.Value))
+			}
+		case "test":
+			if v, e := getValue(out, path); e != nil || !jsonEqual(v, op.Value) {
+				err = &patchTestFailedError{path: op.Path}
+			}
+		case "move", "copy":
+			var from []string
+			var v interface{}
+			if from, err = parsePointer(op.From); err != nil {
+				return nil, err
+			}
+			if v, err = getValue(out, from); err != nil {
+				break
+			}
+			if op.Op == "move" {
+				if out, err = removeValue(out, from); err != nil {
+					break
+				}
+			}
+			out, err = addValue(out, path, copyJSONValue(v))
+		default:
+			err = fmt.Errorf("unknown patch operation %q", op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	m, ok := out.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("patch replaced the object by a %T", out)
+	}
+	return m, nil
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) into its unescaped tokens
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q, it must start with /", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	v := doc
+	for i, t := range path {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = x[t]; !ok {
+				return nil, pointerError(path[:i+1], "not found")
+			}
+		case []interface{}:
+			j, err := strconv.Atoi(t)
+			if err != nil || j < 0 || j >= len(x) {
+				return nil, pointerError(path[:i+1], "not found")
+			}
+			v = x[j]
+		default:
+			return nil, pointerError(path[:i+1], "not found")
+		}
+	}
+	return v, nil
+}
+
+// addValue adds value at path and returns the updated doc. Values are inserted
+// into lists at the given index, "-" appends to a list.
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updatePointer(doc, path, func(parent interface{}, t string) (interface{}, error) {
+		switch x := parent.(type) {
+		case map[string]interface{}:
+			x[t] = value
+			return x, nil
+		case []interface{}:
+			if t == "-" {
+				return append(x, value), nil
+			}
+			j, err := strconv.Atoi(t)
+			if err != nil || j < 0 || j > len(x) {
+				return nil, pointerError(path, "invalid list index")
+			}
+			x = append(x, nil)
+			copy(x[j+1:], x[j:])
+			x[j] = value
+			return x, nil
+		}
+		return nil, pointerError(path, "parent is not an object or list")
+	})
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, pointerError(path, "cannot remove the whole document")
+	}
+	return updatePointer(doc, path, func(parent interface{}, t string) (interface{}, error) {
+		switch x := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := x[t]; !ok {
+				return nil, pointerError(path, "not found")
+			}
+			delete(x, t)
+			return x, nil
+		case []interface{}:
+			j, err := strconv.Atoi(t)
+			if err != nil || j < 0 || j >= len(x) {
+				return nil, pointerError(path, "not found")
+			}
+			return append(x[:j], x[j+1:]...), nil
+		}
+		return nil, pointerError(path, "not found")
+	})
+}
+
+// updatePointer calls fn with the parent of the value path points to and the
+// last token of path. The parent is replaced by the result of fn.
+func updatePointer(doc interface{}, path []string, fn func(parent interface{}, t string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = updatePointer(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch x := doc.(type) {
+	case map[string]interface{}:
+		x[path[0]] = child
+	case []interface{}:
+		j, _ := strconv.Atoi(path[0])
+		x[j] = child
+	}
+	return doc, nil
+}
+
+func pointerError(path []string, reason string) error {
+	return fmt.Errorf("/%s: %s", strings.Join(path, "/"), reason)
+}
+
+// jsonEqual compares two values decoded from JSON or YAML, whose numbers may
+// have different types
+func jsonEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case int, int32, int64, float32, float64:
+		switch b.(type) {
+		case int, int32, int64, float32, float64:
+			return fmt.Sprint(a) == fmt.Sprint(b)
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+const patchDoc = `
+metadata:
+  name: api
+  annotations: {a/b: "1", c~d: "2"}
+spec:
+  replicas: 2
+  serviceAccountName: default
+  ports: [80, 443]
+`
+
+func TestApplyPatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		patch   []PatchOperation
+		want    string
+		wantErr bool
+		// testFailed expects the error of a failed test operation
+		testFailed bool
+	}{
+		{
+			name: "test and remove",
+			patch: []PatchOperation{
+				{Op: "test", Path: "/spec/serviceAccountName", Value: "default"},
+				{Op: "remove", Path: "/spec/serviceAccountName"},
+			},
+			want: `
+metadata:
+  name: api
+  annotations: {a/b: "1", c~d: "2"}
+spec:
+  replicas: 2
+  ports: [80, 443]
+`,
+		},
+		{
+			name: "test compares numbers of any type",
+			patch: []PatchOperation{
+				{Op: "test", Path: "/spec/replicas", Value: int64(2)},
+				{Op: "test", Path: "/spec/ports", Value: []interface{}{80, 443.0}},
+			},
+			want: patchDoc,
+		},
+		{
+			name: "failed test",
+			patch: []PatchOperation{
+				{Op: "test", Path: "/spec/serviceAccountName", Value: "api"},
+				{Op: "remove", Path: "/spec/serviceAccountName"},
+			},
+			wantErr:    true,
+			testFailed: true,
+		},
+		{
+			name: "test of a missing field",
+			patch: []PatchOperation{
+				{Op: "test", Path: "/spec/nodeName", Value: "node-1"},
+			},
+			wantErr:    true,
+			testFailed: true,
+		},
+		{
+			name: "add a field, a list item and an escaped key",
+			patch: []PatchOperation{
+				{Op: "add", Path: "/spec/paused", Value: true},
+				{Op: "add", Path: "/spec/ports/1", Value: 8080},
+				{Op: "add", Path: "/spec/ports/-", Value: 9090},
+				{Op: "add", Path: "/metadata/annotations/e~1f", Value: "3"},
+			},
+			want: `
+metadata:
+  name: api
+  annotations: {a/b: "1", c~d: "2", e/f: "3"}
+spec:
+  replicas: 2
+  serviceAccountName: default
+  ports: [80, 8080, 443, 9090]
+  paused: true
+`,
+		},
+		{
+			name:    "add to a missing parent",
+			patch:   []PatchOperation{{Op: "add", Path: "/status/phase", Value: "Running"}},
+			wantErr: true,
+		},
+		{
+			name:    "add beyond the end of a list",
+			patch:   []PatchOperation{{Op: "add", Path: "/spec/ports/3", Value: 8080}},
+			wantErr: true,
+		},
+		{
+			name: "remove escaped keys and a list item",
+			patch: []PatchOperation{
+				{Op: "remove", Path: "/metadata/annotations/a~1b"},
+				{Op: "remove", Path: "/metadata/annotations/c~0d"},
+				{Op: "remove", Path: "/spec/ports/0"},
+			},
+			want: `
+metadata:
+  name: api
+  annotations: {}
+spec:
+  replicas: 2
+  serviceAccountName: default
+  ports: [443]
+`,
+		},
+		{
+			name:    "remove a missing field",
+			patch:   []PatchOperation{{Op: "remove", Path: "/spec/nodeName"}},
+			wantErr: true,
+		},
+		{
+			name: "replace",
+			patch: []PatchOperation{
+				{Op: "replace", Path: "/spec/replicas", Value: 3},
+			},
+			want: `
+metadata:
+  name: api
+  annotations: {a/b: "1", c~d: "2"}
+spec:
+  replicas: 3
+  serviceAccountName: default
+  ports: [80, 443]
+`,
+		},
+		{
+			name:    "replace a missing field",
+			patch:   []PatchOperation{{Op: "replace", Path: "/spec/nodeName", Value: "node-1"}},
+			wantErr: true,
+		},
+		{
+			name: "move and copy",
+			patch: []PatchOperation{
+				{Op: "move", From: "/spec/serviceAccountName", Path: "/spec/serviceAccount"},
+				{Op: "copy", From: "/metadata/name", Path: "/metadata/labels"},
+			},
+			want: `
+metadata:
+  name: api
+  labels: api
+  annotations: {a/b: "1", c~d: "2"}
+spec:
+  replicas: 2
+  serviceAccount: default
+  ports: [80, 443]
+`,
+		},
+		{
+			name:    "move a missing field",
+			patch:   []PatchOperation{{Op: "move", From: "/spec/nodeName", Path: "/spec/node"}},
+			wantErr: true,
+		},
+		{
+			name: "a failed operation applies nothing",
+			patch: []PatchOperation{
+				{Op: "remove", Path: "/spec/serviceAccountName"},
+				{Op: "replace", Path: "/spec/replicas", Value: 3},
+				{Op: "remove", Path: "/spec/nodeName"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "replace the whole document by a value",
+			patch:   []PatchOperation{{Op: "replace", Path: "", Value: "x"}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			doc := decodeYAML(t, patchDoc)
+			out, err := applyPatch(doc, c.patch)
+			if !reflect.DeepEqual(doc, decodeYAML(t, patchDoc)) {
+				t.Errorf("the patched document was modified: %v", doc)
+			}
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", out)
+				}
+				if _, ok := err.(*patchTestFailedError); ok != c.testFailed {
+					t.Errorf("error %v, failed test expected: %v", err, c.testFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decodeYAML(t, c.want); !jsonEqual(out, want) {
+				t.Errorf("got %v, want %v", out, want)
+			}
+		})
+	}
+}
+
+func TestValidatePatch(t *testing.T) {
+	cases := []struct {
+		patch   []PatchOperation
+		wantErr bool
+	}{
+		{patch: []PatchOperation{{Op: "add", Path: "/a", Value: 1}, {Op: "move", From: "/a", Path: "/b"}}},
+		{patch: []PatchOperation{{Op: "merge", Path: "/a"}}, wantErr: true},
+		{patch: []PatchOperation{{Op: "remove", Path: "a"}}, wantErr: true},
+		{patch: []PatchOperation{{Op: "copy", From: "a", Path: "/b"}}, wantErr: true},
+	}
+	for _, c := range cases {
+		if err := validatePatch(c.patch); (err != nil) != c.wantErr {
+			t.Errorf("validatePatch(%+v) = %v", c.patch, err)
+		}
+	}
+}
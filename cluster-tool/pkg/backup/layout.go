@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// LayoutHierarchical stores namespaced objects as namespaces/<namespace>/<group>/<resource>/<name>.yaml
+	// and cluster scoped objects as cluster/<group>/<resource>/<name>.yaml
+	LayoutHierarchical = "hierarchical"
+	// LayoutFlat stores objects as <group>_<kind>/<namespace>_<name>.yaml, or
+	// <group>_<kind>/<name>.yaml for cluster scoped objects
+	LayoutFlat = "flat"
+
+	// coreGroupDir is used as the group directory of the legacy core group
+	coreGroupDir = "core"
+)
+
+var Layouts = []string{LayoutHierarchical, LayoutFlat}
+
+func ValidateLayout(layout string) error {
+	switch layout {
+	case LayoutHierarchical, LayoutFlat:
+		return nil
+	}
+	return fmt.Errorf("unknown layout %q, must be one of %s", layout, strings.Join(Layouts, ", "))
+}
+
+// objectPath returns the path of an object file relative to the snapshot
+// directory. Names may contain "_" and ":" (i.e. RBAC objects), but never "/".
+// Namespaces are DNS labels without "_", so the "_" joining the namespace and
+// the name in the flat layout keeps paths unique.
+func objectPath(layout string, gv schema.GroupVersion, r metav1.APIResource, namespace, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%s %s has an object without name", gv, r.Name)
+	}
+	group := gv.Group
+	if group == core.GroupName {
+		group = coreGroupDir
+	}
+
+	if layout == LayoutFlat {
+		dir := group + "_" + r.Kind
+		if namespace != "" {
+			return path.Join(dir, namespace+"_"+name+".yaml"), nil
+		}
+		return path.Join(dir, name+".yaml"), nil
+	}
+
+	if namespace != "" {
+		return path.Join("namespaces", namespace, group, r.Name, name+".yaml"), nil
+	}
+	return path.Join("cluster", group, r.Name, name+".yaml"), nil
+}
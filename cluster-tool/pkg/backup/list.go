@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/client-go/rest"
+)
+
+// streamList runs a list request and calls fn for every item of the returned
+// list as it is decoded from the response body, instead of reading the whole
+// list into memory. It returns the continue token of the list.
+func streamList(request *rest.Request, fn func(item map[string]interface{}) error) (string, error) {
+	body, err := request.Stream()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return "", err
+	}
+	var continueToken string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t {
+		case "metadata":
+			var md struct {
+				Continue string `json:"continue,omitempty"`
+			}
+			if err := dec.Decode(&md); err != nil {
+				return "", err
+			}
+			continueToken = md.Continue
+		case "items":
+			t, err := dec.Token()
+			if err != nil {
+				return "", err
+			}
+			if t == nil {
+				continue // "items" is null for empty lists on some api servers
+			}
+			if t != json.Delim('[') {
+				return "", fmt.Errorf("unexpected token %v in list items", t)
+			}
+			for dec.More() {
+				item := map[string]interface{}{}
+				if err := dec.Decode(&item); err != nil {
+					return "", err
+				}
+				if err := fn(item); err != nil {
+					return "", err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return "", err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return "", err
+			}
+		}
+	}
+	return continueToken, expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("expected %v in list response, found %v", delim, t)
+	}
+	return nil
+}
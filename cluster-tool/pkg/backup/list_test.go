@@ -0,0 +1,330 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamic "k8s.io/client-go/deprecated-dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// listedConfigMaps are not sorted, like the lists of some aggregated apis
+var listedConfigMaps = []string{"b/1", "a/2", "c/1", "a/1", "b/2"}
+
+// configMapPage returns the page of listedConfigMaps starting at start
+func configMapPage(start, limit int) []byte {
+	end := start + limit
+	continueToken := strconv.Itoa(end)
+	if end >= len(listedConfigMaps) {
+		end = len(listedConfigMaps)
+		continueToken = ""
+	}
+	var items []string
+	for _, key := range listedConfigMaps[start:end] {
+		parts := strings.Split(key, "/")
+		items = append(items, fmt.Sprintf(`{"metadata":{"namespace":%q,"name":%q},"data":{"k":"v"}}`, parts[0], parts[1]))
+	}
+	return []byte(fmt.Sprintf(`{"kind":"ConfigMapList","apiVersion":"v1","metadata":{"continue":%q},"items":[%s]}`, continueToken, strings.Join(items, ",")))
+}
+
+func writeStatus(w http.ResponseWriter, status metav1.Status) {
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	status.Status = metav1.StatusFailure
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(status.Code))
+	json.NewEncoder(w).Encode(status)
+}
+
+func TestBackupResourceRetries(t *testing.T) {
+	listRetryDelay = time.Millisecond
+	defer func() { listRetryDelay = time.Second }()
+
+	cases := []struct {
+		name string
+		// fail is called with the continue token and the number of earlier
+		// requests with it. It returns true if it wrote a failure.
+		fail    func(w http.ResponseWriter, token string, calls int) bool
+		want    []string
+		wantErr bool
+		// starts is the number of requests listing from the start
+		starts int
+	}{
+		{
+			name: "expired continue token with a new token starts over",
+			fail: func(w http.ResponseWriter, token string, calls int) bool {
+				if token == "2" && calls == 0 {
+					writeStatus(w, metav1.Status{Code: http.StatusGone, Reason: metav1.StatusReasonExpired, ListMeta: metav1.ListMeta{Continue: "2"}})
+					return true
+				}
+				return false
+			},
+			want:   listedConfigMaps,
+			starts: 2,
+		},
+		{
+			name: "expired continue token restarts the list",
+			fail: func(w http.ResponseWriter, token string, calls int) bool {
+				if token == "4" && calls == 0 {
+					writeStatus(w, metav1.Status{Code: http.StatusGone, Reason: metav1.StatusReasonExpired})
+					return true
+				}
+				return false
+			},
+			want:   listedConfigMaps,
+			starts: 2,
+		},
+		{
+			name: "too many requests",
+			fail: func(w http.ResponseWriter, token string, calls int) bool {
+				if calls < 2 {
+					writeStatus(w, metav1.Status{Code: http.StatusTooManyRequests, Reason: metav1.StatusReasonTooManyRequests})
+					return true
+				}
+				return false
+			},
+			want:   listedConfigMaps,
+			starts: 3,
+		},
+		{
+			name: "connection closed while streaming",
+			fail: func(w http.ResponseWriter, token string, calls int) bool {
+				if token == "2" && calls == 0 {
+					page := configMapPage(2, 2)
+					w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+					w.Write(page[:len(page)-40])
+					return true
+				}
+				return false
+			},
+			want:   listedConfigMaps,
+			starts: 1,
+		},
+		{
+			name: "forbidden",
+			fail: func(w http.ResponseWriter, token string, calls int) bool {
+				writeStatus(w, metav1.Status{Code: http.StatusForbidden, Reason: metav1.StatusReasonForbidden})
+				return true
+			},
+			wantErr: true,
+		},
+		{
+			name: "retries exhausted",
+			fail: func(w http.ResponseWriter, token string, calls int) bool {
+				writeStatus(w, metav1.Status{Code: http.StatusServiceUnavailable, Reason: metav1.StatusReasonServiceUnavailable})
+				return true
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := map[string]int{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				token := r.URL.Query().Get("continue")
+				n := calls[token]
+				calls[token]++
+				mu.Unlock()
+
+				if c.fail(w, token, n) {
+					return
+				}
+				start, _ := strconv.Atoi(token)
+				limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(configMapPage(start, limit))
+			}))
+			defer srv.Close()
+
+			config := &rest.Config{Host: srv.URL}
+			if err := rest.SetKubernetesDefaults(config); err != nil {
+				t.Fatal(err)
+			}
+			config.ContentConfig = dynamic.ContentConfig()
+			mgr := NewBackupManager("", config, Options{ChunkSize: 2, Layout: LayoutHierarchical})
+			gv := schema.GroupVersion{Version: "v1"}
+			client, err := mgr.restClientFor(gv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			err = mgr.backupResource(client, resourceTask{gv: gv, resource: metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true}}, func(f dumpedFile) error {
+				got = append(got, f.namespace+"/"+f.name)
+				return nil
+			})
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("listed %v, want %v", got, c.want)
+			}
+			if calls[""] != c.starts {
+				t.Errorf("listed from the start %d times, want %d", calls[""], c.starts)
+			}
+		})
+	}
+}
+
+func TestBackupEphemeralObjects(t *testing.T) {
+	var mu sync.Mutex
+	var fieldSelectors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/services":
+			w.Write([]byte(`{"kind":"ServiceList","apiVersion":"v1","metadata":{},"items":[
+				{"metadata":{"namespace":"payments","name":"api"},"spec":{"selector":{"app":"api"}}},
+				{"metadata":{"namespace":"payments","name":"external-db"},"spec":{"type":"ClusterIP"}}]}`))
+		case "/api/v1/endpoints":
+			w.Write([]byte(`{"kind":"EndpointsList","apiVersion":"v1","metadata":{},"items":[
+				{"metadata":{"namespace":"payments","name":"api"}},
+				{"metadata":{"namespace":"payments","name":"external-db"}},
+				{"metadata":{"namespace":"payments","name":"orphan"}}]}`))
+		case "/api/v1/namespaces/kube-system/secrets":
+			mu.Lock()
+			fieldSelectors = append(fieldSelectors, r.URL.Query().Get("fieldSelector"))
+			mu.Unlock()
+			w.Write([]byte(`{"kind":"SecretList","apiVersion":"v1","metadata":{},"items":[
+				{"metadata":{"namespace":"kube-system","name":"bootstrap"},"type":"Opaque"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := &rest.Config{Host: srv.URL}
+	if err := rest.SetKubernetesDefaults(config); err != nil {
+		t.Fatal(err)
+	}
+	config.ContentConfig = dynamic.ContentConfig()
+	mgr := NewBackupManager("", config, Options{FieldSelector: "metadata.name!=ignored", Layout: LayoutHierarchical, SecretsMode: SecretsRedact})
+	gv := schema.GroupVersion{Version: "v1"}
+	client, err := mgr.restClientFor(gv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := func(task resourceTask) []string {
+		var got []string
+		err := mgr.backupResource(client, task, func(f dumpedFile) error {
+			got = append(got, f.namespace+"/"+f.name)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	services, err := mgr.listSelectedServices(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := metav1.APIResource{Name: "endpoints", Kind: "Endpoints", Namespaced: true}
+	got := list(resourceTask{gv: gv, resource: endpoints, skip: services})
+	if want := []string{"payments/external-db", "payments/orphan"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dumped Endpoints %v, want %v", got, want)
+	}
+
+	secrets := metav1.APIResource{Name: "secrets", Kind: "Secret", Namespaced: true}
+	list(resourceTask{gv: gv, resource: secrets, namespace: "kube-system", fieldSelector: "type!=kubernetes.io/service-account-token"})
+	want := []string{"metadata.name!=ignored,type!=kubernetes.io/service-account-token"}
+	if !reflect.DeepEqual(fieldSelectors, want) {
+		t.Errorf("listed Secrets with field selectors %q, want %q", fieldSelectors, want)
+	}
+}
+
+func TestListNamespacesAndServicesInChunks(t *testing.T) {
+	namespaces := []string{"kube-system", "payments", "payments-staging", "web"}
+	services := []string{`{"metadata":{"namespace":"payments","name":"api"},"spec":{"selector":{"app":"api"}}}`,
+		`{"metadata":{"namespace":"payments","name":"external-db"},"spec":{"type":"ClusterIP"}}`,
+		`{"metadata":{"namespace":"payments","name":"worker"},"spec":{"selector":{"app":"worker"}}}`}
+	var mu sync.Mutex
+	var limits []string
+	expired := false
+	page := func(w http.ResponseWriter, r *http.Request, items []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		limits = append(limits, r.URL.Query().Get("limit"))
+		start, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+		if start == 1 && !expired {
+			expired = true
+			writeStatus(w, metav1.Status{Code: http.StatusGone, Reason: metav1.StatusReasonExpired, Message: "expired"})
+			return
+		}
+		continueToken := ""
+		if start+1 < len(items) {
+			continueToken = strconv.Itoa(start + 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"apiVersion":"v1","metadata":{"continue":%q},"items":[%s]}`, continueToken, items[start])
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces":
+			var items []string
+			for _, ns := range namespaces {
+				items = append(items, fmt.Sprintf(`{"metadata":{"name":%q}}`, ns))
+			}
+			page(w, r, items)
+		case "/api/v1/namespaces/payments/services":
+			page(w, r, services)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := &rest.Config{Host: srv.URL}
+	if err := rest.SetKubernetesDefaults(config); err != nil {
+		t.Fatal(err)
+	}
+	config.ContentConfig = dynamic.ContentConfig()
+	mgr := NewBackupManager("", config, Options{ChunkSize: 1, Filter: ResourceFilter{IncludeNamespaces: []string{"payments*"}}})
+
+	got, err := mgr.listNamespaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"payments", "payments-staging"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listNamespaces() = %v, want %v", got, want)
+	}
+
+	expired = false
+	selected, err := mgr.listSelectedServices([]string{"payments"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"payments/api", "payments/worker"}; !reflect.DeepEqual(selected.List(), want) {
+		t.Errorf("listSelectedServices() = %v, want %v", selected.List(), want)
+	}
+
+	for _, limit := range limits {
+		if limit != "1" {
+			t.Fatalf("listed with limits %v, want 1", limits)
+		}
+	}
+	// both lists start over once, when the token of their second chunk expires
+	if want := len(namespaces) + 2 + len(services) + 2; len(limits) != want {
+		t.Errorf("sent %d list requests, want %d", len(limits), want)
+	}
+}
@@ -0,0 +1,683 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdh"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/sets"
+	dynamic "k8s.io/client-go/deprecated-dynamic"
+	"k8s.io/client-go/discovery"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	timestampFormat = "20060102T150405"
+)
+
+type ItemList struct {
+	Items []map[string]interface{} `json:"items,omitempty"`
+}
+
+type BackupManager struct {
+	cluster       string
+	config        *rest.Config
+	sanitize      bool
+	sanitizeRules *SanitizeRules
+	sanitizer     *Sanitizer
+	filter        ResourceFilter
+	owned         OwnedPolicy
+
+	includeEphemeral bool
+
+	labelSelector string
+	fieldSelector string
+
+	secretsMode      string
+	secretsPublicKey *ecdh.PublicKey
+
+	chunkSize   int64
+	concurrency int
+	bestEffort  bool
+
+	toolVersion string
+	layout      string
+}
+
+type Options struct {
+	// Sanitize removes the server populated fields from dumped objects
+	Sanitize bool
+	// SanitizeRules replace the default profile used by Sanitize
+	SanitizeRules *SanitizeRules
+	// Filter selects the namespaces and resources to dump
+	Filter ResourceFilter
+	// IncludeEphemeral dumps the EphemeralResources and the ephemeral Secrets,
+	// which are skipped by default. An ephemeral resource named by the include
+	// list of Filter is dumped anyway.
+	IncludeEphemeral bool
+	// Owned leaves out the objects whose controller is dumped too. Built-in
+	// controllers are listed before the objects they own. Owned objects listed
+	// before their controller are held in memory until every resource has been listed.
+	Owned OwnedPolicy
+	// LabelSelector is passed to every list call
+	LabelSelector string
+	// FieldSelector is passed to every list call. Resources rejecting it are skipped.
+	FieldSelector string
+	// SecretsMode is one of SecretsSkip, SecretsRedact or SecretsEncrypt.
+	// Secret data is never written in plain text, so it defaults to SecretsSkip.
+	SecretsMode string
+	// SecretsPublicKey is the X25519 key Secret values are encrypted for with SecretsEncrypt
+	SecretsPublicKey *ecdh.PublicKey
+	// ChunkSize is the maximum number of objects returned by a single list call.
+	// Zero lists all objects of a resource at once.
+	ChunkSize int64
+	// Concurrency is the number of resources listed in parallel
+	Concurrency int
+	// BestEffort skips the group/versions and resources that fail to be
+	// discovered or listed instead of failing the whole backup. The skipped
+	// ones are reported with a PartialBackupError.
+	BestEffort bool
+	// ToolVersion is recorded in the manifest of every snapshot
+	ToolVersion string
+	// Layout is the directory layout of dumped objects, either LayoutHierarchical or LayoutFlat
+	Layout string
+}
+
+func NewBackupManager(cluster string, config *rest.Config, opt Options) BackupManager {
+	return BackupManager{
+		cluster:       cluster,
+		config:        config,
+		sanitize:      opt.Sanitize,
+		sanitizeRules: opt.SanitizeRules,
+		filter:        opt.Filter,
+		owned:         opt.Owned,
+
+		includeEphemeral: opt.IncludeEphemeral,
+
+		labelSelector: opt.LabelSelector,
+		fieldSelector: opt.FieldSelector,
+
+		secretsMode:      opt.SecretsMode,
+		secretsPublicKey: opt.SecretsPublicKey,
+
+		chunkSize:   opt.ChunkSize,
+		concurrency: opt.Concurrency,
+		bestEffort:  opt.BestEffort,
+
+		toolVersion: opt.ToolVersion,
+		layout:      opt.Layout,
+	}
+}
+
+// processorFunc receives every dumped file. Backup calls it from a single
+// goroutine, in a deterministic order.
+type processorFunc func(relPath string, data []byte) error
+
+func (mgr BackupManager) snapshotPrefix(t time.Time) string {
+	if mgr.cluster == "" {
+		return "snapshot-" + t.UTC().Format(timestampFormat)
+	}
+	return mgr.cluster + "-" + t.UTC().Format(timestampFormat)
+}
+
+func (mgr BackupManager) BackupToDir(backupDir string) (string, error) {
+	snapshotDir := mgr.snapshotPrefix(time.Now())
+	p := func(relPath string, data []byte) error {
+		absPath := filepath.Join(backupDir, snapshotDir, relPath)
+		dir := filepath.Dir(absPath)
+		err := os.MkdirAll(dir, 0777)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(absPath, data, 0644)
+	}
+	return snapshotDir, mgr.Backup(p)
+}
+
+func (mgr BackupManager) BackupToTar(backupDir string) (string, error) {
+	err := os.MkdirAll(backupDir, 0777)
+	if err != nil {
+		return "", err
+	}
+
+	t := time.Now()
+	prefix := mgr.snapshotPrefix(t)
+
+	fileName := filepath.Join(backupDir, prefix+".tar.gz")
+	file, err := os.Create(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	// set up the gzip writer
+	gw := gzip.NewWriter(file)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	var mu sync.Mutex
+	p := func(relPath string, data []byte) error {
+		// a tar stream can only be written sequentially
+		mu.Lock()
+		defer mu.Unlock()
+
+		// now lets create the header as needed for this file within the tarball
+		header := new(tar.Header)
+		header.Name = relPath
+		header.Size = int64(len(data))
+		header.Mode = 0666
+		header.ModTime = t
+		// write the header to the tarball archive
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		// copy the file data to the tarball
+		if _, err := io.Copy(tw, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		return nil
+	}
+	return fileName, mgr.Backup(p)
+}
+
+// BackupToObjects returns the objects a backup would dump, without writing them anywhere
+func (mgr BackupManager) BackupToObjects() ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	p := func(relPath string, data []byte) error {
+		if relPath == ResourceListsFile || relPath == MetadataFile || relPath == ManifestFile {
+			return nil
+		}
+		js, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(js); err != nil {
+			return err
+		}
+		objects = append(objects, obj)
+		return nil
+	}
+	err := mgr.Backup(p)
+	return objects, err
+}
+
+func (mgr BackupManager) Backup(process processorFunc) error {
+	// ref: https://github.com/kubernetes/ingress-nginx/blob/0dab51d9eb1e5a9ba3661f351114825ac8bfc1af/pkg/ingress/controller/launch.go#L252
+	mgr.config.QPS = 1e6
+	mgr.config.Burst = 1e6
+	if err := rest.SetKubernetesDefaults(mgr.config); err != nil {
+		return err
+	}
+	mgr.config.ContentConfig = dynamic.ContentConfig()
+
+	if mgr.sanitize {
+		sanitizer, err := NewSanitizer(mgr.sanitizeRules)
+		if err != nil {
+			return err
+		}
+		mgr.sanitizer = sanitizer
+	}
+
+	disClient, err := discovery.NewDiscoveryClientForConfig(mgr.config)
+	if err != nil {
+		return err
+	}
+	var failures []Failure
+	resourceLists, err := disClient.ServerPreferredResources()
+	if mgr.bestEffort && discovery.IsGroupDiscoveryFailedError(err) {
+		// keep going with the group/versions that could be discovered
+		failures = discoveryFailures(err.(*discovery.ErrGroupDiscoveryFailed))
+		for _, f := range failures {
+			glog.Warningf("Skipping %s, discovery failed: %s", f.GroupVersion, f.Error)
+		}
+	} else if err != nil {
+		return err
+	}
+	resourceListBytes, err := yaml.Marshal(resourceLists)
+	if err != nil {
+		return err
+	}
+	err = process(ResourceListsFile, resourceListBytes)
+	if err != nil {
+		return err
+	}
+	metadataBytes, err := yaml.Marshal(mgr.Metadata())
+	if err != nil {
+		return err
+	}
+	err = process(MetadataFile, metadataBytes)
+	if err != nil {
+		return err
+	}
+
+	var namespaces []string
+	if mgr.filter.NamespaceFiltered() {
+		namespaces, err = mgr.listNamespaces()
+		if err != nil {
+			return err
+		}
+	}
+
+	var tasks []resourceTask
+	// selectedServices are the Services whose Endpoints are left out as ephemeral
+	var selectedServices sets.String
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return err
+		}
+		for _, r := range list.APIResources {
+			if strings.ContainsRune(r.Name, '/') {
+				continue // skip subresource
+			}
+			if !sets.NewString(r.Verbs...).HasAll("list", "get") {
+				continue
+			}
+			if isSecret(gv, r) && mgr.secretsMode != SecretsRedact && mgr.secretsMode != SecretsEncrypt {
+				glog.V(3).Infof("Skipping %s apiVersion:%s kind:%s, secrets mode is %s", list.GroupVersion, r.Name, r.Kind, SecretsSkip)
+				continue
+			}
+			if !mgr.filter.IncludesResource(gv, r) {
+				glog.V(5).Infof("Skipping %s apiVersion:%s kind:%s", list.GroupVersion, r.Name, r.Kind)
+				continue
+			}
+			var skip sets.String
+			if !mgr.includeEphemeral && excludesEphemeral(mgr.filter, gv, r) {
+				if !isEndpoints(gv, r) {
+					glog.V(5).Infof("Skipping %s apiVersion:%s kind:%s, resource is ephemeral", list.GroupVersion, r.Name, r.Kind)
+					continue
+				}
+				if selectedServices == nil {
+					selectedServices, err = mgr.listSelectedServices(namespaces)
+					if err != nil && !mgr.bestEffort {
+						return err
+					} else if err != nil {
+						glog.Warningf("Skipping %s apiVersion:%s kind:%s, failed to list the Services: %v", list.GroupVersion, r.Name, r.Kind, err)
+						failures = append(failures, Failure{GroupVersion: list.GroupVersion, Resource: r.Name, Error: err.Error()})
+						continue
+					}
+				}
+				// only the Endpoints of Services without a selector are kept
+				skip = selectedServices
+			}
+
+			var resourceTasks []resourceTask
+			switch {
+			case !mgr.filter.NamespaceFiltered():
+				resourceTasks = append(resourceTasks, resourceTask{gv: gv, resource: r, skip: skip})
+			case r.Namespaced:
+				for _, ns := range namespaces {
+					resourceTasks = append(resourceTasks, resourceTask{gv: gv, resource: r, namespace: ns, skip: skip})
+				}
+			case gv.Group == core.GroupName && r.Name == "namespaces" || mgr.filter.IncludesClusterScoped():
+				resourceTasks = append(resourceTasks, resourceTask{gv: gv, resource: r})
+			default:
+				// cluster scoped resources do not belong to any of the included namespaces
+			}
+			for _, t := range resourceTasks {
+				if isSecret(gv, r) && !mgr.includeEphemeral {
+					tasks = append(tasks, withoutEphemeralSecrets(t)...)
+				} else {
+					tasks = append(tasks, t)
+				}
+			}
+		}
+	}
+	manifest := Manifest{
+		SnapshotMetadata: mgr.Metadata(),
+		ToolVersion:      mgr.toolVersion,
+		Timestamp:        time.Now().UTC(),
+		Objects:          []ManifestEntry{},
+		SelectedServices: selectedServices.List(),
+	}
+	if info, err := disClient.ServerVersion(); err == nil {
+		manifest.ServerVersion = info.GitVersion
+	}
+
+	if mgr.owned.Enabled() {
+		sortOwnedTasks(tasks)
+	}
+	owned := newOwnedTracker()
+	emit := func(f dumpedFile) error {
+		if err := process(f.relPath, f.data); err != nil {
+			return err
+		}
+		manifest.Objects = append(manifest.Objects, newManifestEntry(f))
+		return nil
+	}
+	taskFailures, err := mgr.runTasks(tasks, func(f dumpedFile) error {
+		if !owned.add(f) {
+			return nil
+		}
+		return emit(f)
+	})
+	if err != nil {
+		return err
+	}
+	failures = append(failures, taskFailures...)
+
+	kept, skipped := owned.resolve()
+	for _, f := range kept {
+		if err := emit(f); err != nil {
+			return err
+		}
+	}
+	for _, s := range skipped {
+		glog.V(3).Infof("Skipping %s %s/%s, owned by %s %s", s.Kind, s.Namespace, s.Name, s.Owner.Kind, s.Owner.Name)
+	}
+	manifest.SkippedOwned = skipped
+
+	manifest.Failures = failures
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = process(ManifestFile, manifestBytes)
+	if err != nil {
+		return err
+	}
+
+	if len(failures) > 0 {
+		return &PartialBackupError{Failures: failures}
+	}
+	return nil
+}
+
+// listNamespaces returns the namespaces selected by the filter. If the filter
+// only names plain namespaces, they are used as is, so that a backup scoped to
+// a few namespaces does not need permission to list all namespaces.
+func (mgr BackupManager) listNamespaces() ([]string, error) {
+	if namespaces, ok := mgr.filter.literalNamespaces(); ok {
+		return namespaces, nil
+	}
+
+	client, err := mgr.restClientFor(core.SchemeGroupVersion)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := sets.NewString()
+	err = mgr.listAll(client, metav1.NamespaceAll, "namespaces", func(item map[string]interface{}) error {
+		if name := getName(item["metadata"]); name != "" && mgr.filter.IncludesNamespace(name) {
+			namespaces.Insert(name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return namespaces.List(), nil
+}
+
+// listSelectedServices returns the namespace/name keys of the Services with a
+// selector in namespaces, or in all namespaces if the namespaces are not
+// filtered. Their Endpoints are maintained by the endpoints controller.
+func (mgr BackupManager) listSelectedServices(namespaces []string) (sets.String, error) {
+	if !mgr.filter.NamespaceFiltered() {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	client, err := mgr.restClientFor(core.SchemeGroupVersion)
+	if err != nil {
+		return nil, err
+	}
+	services := sets.NewString()
+	for _, ns := range namespaces {
+		err := mgr.listAll(client, ns, "services", func(item map[string]interface{}) error {
+			spec, _ := item["spec"].(map[string]interface{})
+			if selector, _ := spec["selector"].(map[string]interface{}); len(selector) > 0 {
+				services.Insert(getNamespace(item["metadata"]) + "/" + getName(item["metadata"]))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return services, nil
+}
+
+// listAll passes the objects of a resource in namespace ns to fn. Objects are
+// listed in chunks of mgr.chunkSize. If the continue token expires, the list
+// starts over and fn sees the objects of the first chunks again.
+func (mgr BackupManager) listAll(client *rest.RESTClient, ns, resource string, fn func(item map[string]interface{}) error) error {
+	continueToken := ""
+	restarts := 0
+	for {
+		request := client.Get().Namespace(ns).Resource(resource)
+		if mgr.chunkSize > 0 {
+			request = request.Param("limit", strconv.FormatInt(mgr.chunkSize, 10))
+		}
+		if continueToken != "" {
+			request = request.Param("continue", continueToken)
+		}
+		next, err := streamList(request, fn)
+		if err != nil && continueToken != "" && restarts < maxListRetries && (kerr.IsResourceExpired(err) || kerr.IsGone(err)) {
+			glog.Warningf("Continue token of %s namespace:%s expired, listing again: %v", resource, ns, err)
+			continueToken = ""
+			restarts++
+			continue
+		}
+		if err != nil || next == "" {
+			return err
+		}
+		continueToken = next
+	}
+}
+
+func (mgr BackupManager) restClientFor(gv schema.GroupVersion) (*rest.RESTClient, error) {
+	// copy the config, clients are created from many workers at once
+	config := rest.CopyConfig(mgr.config)
+	config.GroupVersion = &gv
+	config.APIPath = "/apis"
+	if gv.Group == core.GroupName {
+		config.APIPath = "/api"
+	}
+	return rest.RESTClientFor(config)
+}
+
+// backupResource dumps the objects of task t. Objects are listed in chunks of
+// mgr.chunkSize and every object is processed as soon as it is decoded, so
+// only the keys of the processed objects are held in memory.
+//
+// Transient errors are retried from the continue token of the failed chunk. If
+// the continue token expires, the list starts over. Aggregated apis do not
+// have to order their lists, so the objects processed before a retry are
+// skipped by their key.
+func (mgr BackupManager) backupResource(client *rest.RESTClient, t resourceTask, emit emitFunc) error {
+	gv, r, ns := t.gv, t.resource, t.namespace
+	fieldSelector := mgr.fieldSelector
+	if t.fieldSelector != "" && fieldSelector != "" {
+		fieldSelector += "," + t.fieldSelector
+	} else if t.fieldSelector != "" {
+		fieldSelector = t.fieldSelector
+	}
+	continueToken := ""
+	// processed holds the keys of the objects processed before a retry
+	processed := sets.NewString()
+	retries := 0
+	for {
+		request := client.Get().Namespace(ns).Resource(r.Name)
+		if mgr.labelSelector != "" {
+			request = request.Param("labelSelector", mgr.labelSelector)
+		}
+		if fieldSelector != "" {
+			request = request.Param("fieldSelector", fieldSelector)
+		}
+		if mgr.chunkSize > 0 {
+			request = request.Param("limit", strconv.FormatInt(mgr.chunkSize, 10))
+		}
+		if continueToken != "" {
+			request = request.Param("continue", continueToken)
+		}
+
+		processedBefore := processed.Len()
+		next, err := streamList(request, func(item map[string]interface{}) error {
+			md := item["metadata"]
+			key := getNamespace(md) + "/" + getName(md)
+			if processed.Has(key) || t.skip.Has(key) {
+				return nil
+			}
+			if err := mgr.processItem(gv, r, item, emit); err != nil {
+				return err
+			}
+			processed.Insert(key)
+			return nil
+		})
+		if mgr.fieldSelector != "" && kerr.IsBadRequest(err) {
+			// most resources only support field selectors on metadata.name and metadata.namespace
+			glog.Warningf("Skipping %s apiVersion:%s kind:%s, field selector not supported: %v", gv, r.Name, r.Kind, err)
+			return nil
+		}
+		if err == errAborted {
+			return err
+		}
+		if err != nil {
+			if processed.Len() != processedBefore {
+				retries = 0
+			}
+			if retries >= maxListRetries {
+				return err
+			}
+			retries++
+
+			switch {
+			case continueToken != "" && (kerr.IsResourceExpired(err) || kerr.IsGone(err)):
+				continueToken = ""
+				glog.Warningf("Continue token of %s apiVersion:%s kind:%s namespace:%s expired, listing again without the %d processed objects: %v", gv, r.Name, r.Kind, ns, processed.Len(), err)
+			case isTransientListError(err):
+				delay := time.Duration(1<<uint(retries-1)) * listRetryDelay
+				if seconds, ok := kerr.SuggestsClientDelay(err); ok {
+					delay = time.Duration(seconds) * time.Second
+				}
+				glog.Warningf("Retrying to list %s apiVersion:%s kind:%s namespace:%s in %v: %v", gv, r.Name, r.Kind, ns, delay, err)
+				time.Sleep(delay)
+			default:
+				return err
+			}
+			continue
+		}
+		if next == "" {
+			return nil
+		}
+		continueToken = next
+		retries = 0
+	}
+}
+
+// maxListRetries is the number of times a list request is retried without
+// any object being processed in between
+const maxListRetries = 5
+
+// listRetryDelay is the delay before the first retry, it doubles on every retry
+var listRetryDelay = time.Second
+
+// isTransientListError reports whether a failed list request may succeed if it is retried
+func isTransientListError(err error) bool {
+	if kerr.IsTooManyRequests(err) || kerr.IsServerTimeout(err) || kerr.IsTimeout(err) ||
+		kerr.IsServiceUnavailable(err) || kerr.IsInternalError(err) || kerr.IsUnexpectedServerError(err) {
+		return true
+	}
+	if _, ok := err.(kerr.APIStatus); ok {
+		return false
+	}
+	// the connection was closed or timed out while reading the list
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.ErrUnexpectedEOF || utilnet.IsProbableEOF(err)
+}
+
+func (mgr BackupManager) processItem(gv schema.GroupVersion, r metav1.APIResource, item map[string]interface{}, emit emitFunc) error {
+	item["apiVersion"] = gv.String()
+	item["kind"] = r.Kind
+
+	md := item["metadata"]
+	if gv.Group == core.GroupName && r.Name == "namespaces" && !mgr.filter.IncludesNamespace(getName(md)) {
+		return nil
+	}
+	path, err := objectPath(mgr.layout, gv, r, getNamespace(md), getName(md))
+	if err != nil {
+		return err
+	}
+	// the uid and owner references may be removed by the sanitizer
+	obj := &unstructured.Unstructured{Object: item}
+	uid := string(obj.GetUID())
+	var controller *metav1.OwnerReference
+	if mgr.owned.skips(gv, r) {
+		controller = metav1.GetControllerOf(obj)
+	}
+	if isSecret(gv, r) {
+		ok, err := mgr.processSecret(item)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	if mgr.sanitize {
+		ok, err := mgr.sanitizer.Sanitize(item)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	data, err := yaml.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return emit(dumpedFile{
+		relPath:    path,
+		data:       data,
+		apiVersion: gv.String(),
+		kind:       r.Kind,
+		namespace:  getNamespace(md),
+		name:       getName(md),
+		uid:        uid,
+		controller: controller,
+	})
+}
+
+func isSecret(gv schema.GroupVersion, r metav1.APIResource) bool {
+	return gv.Group == core.GroupName && r.Name == "secrets"
+}
+
+func getNamespace(md interface{}) string {
+	meta, ok := md.(map[string]interface{})
+	if ok {
+		namespace, _ := meta["namespace"].(string)
+		return namespace
+	}
+	return ""
+}
+
+func getName(md interface{}) string {
+	meta, ok := md.(map[string]interface{})
+	if ok {
+		name, _ := meta["name"].(string)
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ManifestFile indexes every object dumped in a snapshot
+	ManifestFile = "manifest.json"
+)
+
+// Manifest describes a snapshot and lists every object written to it, so that
+// the consumers of a snapshot do not have to parse every file to find an object.
+type Manifest struct {
+	SnapshotMetadata `json:",inline"`
+	// ServerVersion is the git version of the backed up api server
+	ServerVersion string `json:"serverVersion,omitempty"`
+	// ToolVersion is the version of the tool that took the snapshot
+	ToolVersion string `json:"toolVersion,omitempty"`
+	// Timestamp shows when the snapshot was taken
+	Timestamp time.Time `json:"timestamp"`
+	// Objects lists the dumped objects in the order they were written
+	Objects []ManifestEntry `json:"objects"`
+	// Failures lists what a best-effort backup skipped
+	Failures []Failure `json:"failures,omitempty"`
+	// SkippedOwned lists the objects left out because their controller is in the snapshot
+	SkippedOwned []SkippedObject `json:"skippedOwned,omitempty"`
+	// SelectedServices lists the namespace/name keys of the Services with a
+	// selector. Their Endpoints are left out as ephemeral.
+	SelectedServices []string `json:"selectedServices,omitempty"`
+}
+
+type ManifestEntry struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Path of the object file relative to the snapshot directory
+	Path string `json:"path"`
+	// SHA256 is the hex encoded checksum of the object file
+	SHA256 string `json:"sha256"`
+	// Size of the object file in bytes
+	Size int64 `json:"size"`
+}
+
+func newManifestEntry(f dumpedFile) ManifestEntry {
+	sum := sha256.Sum256(f.data)
+	return ManifestEntry{
+		APIVersion: f.apiVersion,
+		Kind:       f.kind,
+		Namespace:  f.namespace,
+		Name:       f.name,
+		Path:       strings.TrimPrefix(filepath.ToSlash(f.relPath), "/"),
+		SHA256:     hex.EncodeToString(sum[:]),
+		Size:       int64(len(f.data)),
+	}
+}
+
+// ReadManifest reads the Manifest stored in snapshotDir. It returns an error
+// satisfying os.IsNotExist for snapshots taken before manifests were written.
+func ReadManifest(snapshotDir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(snapshotDir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(data, m)
+	return m, err
+}
+
+// Covers reports whether the snapshot m describes could hold obj: obj passes
+// its filter, selectors and secrets mode, was not left out as ephemeral or
+// owned, and its resource did not fail. The resource of obj is guessed from
+// its kind, as snapshots do not record it.
+func (m *Manifest) Covers(obj *unstructured.Unstructured) bool {
+	gv, err := schema.ParseGroupVersion(obj.GetAPIVersion())
+	if err != nil {
+		return false
+	}
+	plural, _ := meta.UnsafeGuessKindToResource(gv.WithKind(obj.GetKind()))
+	r := metav1.APIResource{Name: plural.Resource, Kind: obj.GetKind(), Namespaced: obj.GetNamespace() != ""}
+
+	if m.Filter != nil {
+		if !m.Filter.IncludesResource(gv, r) {
+			return false
+		}
+		switch {
+		case r.Namespaced:
+			if !m.Filter.IncludesNamespace(obj.GetNamespace()) {
+				return false
+			}
+		case gv.Group == core.GroupName && r.Name == "namespaces":
+			if !m.Filter.IncludesNamespace(obj.GetName()) {
+				return false
+			}
+		case !m.Filter.IncludesClusterScoped():
+			return false
+		}
+	}
+	if m.LabelSelector != "" {
+		selector, err := labels.Parse(m.LabelSelector)
+		if err != nil || !selector.Matches(labels.Set(obj.GetLabels())) {
+			return false
+		}
+	}
+	if m.FieldSelector != "" {
+		selector, err := fields.ParseSelector(m.FieldSelector)
+		if err != nil || !selector.Matches(fieldValues(obj, selector)) {
+			return false
+		}
+	}
+	if gv.Group == core.GroupName && r.Name == "secrets" && (m.SecretsMode == SecretsSkip || m.EphemeralExcluded && isEphemeralSecret(obj.Object)) {
+		return false
+	}
+	if m.EphemeralExcluded && m.excludesEphemeral(gv, r) {
+		// only the Endpoints of the Services with a selector are left out
+		if !isEndpoints(gv, r) || m.selectsEndpoints(obj) {
+			return false
+		}
+	}
+	for _, o := range m.SkippedOwned {
+		if o.APIVersion == obj.GetAPIVersion() && o.Kind == obj.GetKind() && o.Namespace == obj.GetNamespace() && o.Name == obj.GetName() {
+			return false
+		}
+	}
+	for _, f := range m.Failures {
+		if f.GroupVersion == gv.String() && (f.Resource == "" || f.Resource == r.Name) &&
+			(f.Namespace == "" || f.Namespace == obj.GetNamespace()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Manifest) selectsEndpoints(obj *unstructured.Unstructured) bool {
+	key := obj.GetNamespace() + "/" + obj.GetName()
+	for _, s := range m.SelectedServices {
+		if s == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manifest) excludesEphemeral(gv schema.GroupVersion, r metav1.APIResource) bool {
+	var filter ResourceFilter
+	if m.Filter != nil {
+		filter = *m.Filter
+	}
+	return excludesEphemeral(filter, gv, r)
+}
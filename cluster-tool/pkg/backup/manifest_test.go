@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestManifestCovers(t *testing.T) {
+	configMap := &unstructured.Unstructured{Object: decodeYAML(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: api
+  namespace: payments
+  labels:
+    app: api
+`)}
+	tokenSecret := &unstructured.Unstructured{Object: decodeYAML(t, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: deployment-controller-token-x7k2p
+  namespace: kube-system
+type: kubernetes.io/service-account-token
+`)}
+	event := &unstructured.Unstructured{Object: decodeYAML(t, `
+apiVersion: v1
+kind: Event
+metadata:
+  name: api.15f3a1c2d4e5b6a7
+  namespace: payments
+`)}
+	managedEndpoints := &unstructured.Unstructured{Object: decodeYAML(t, `
+apiVersion: v1
+kind: Endpoints
+metadata:
+  name: api
+  namespace: payments
+`)}
+	endpoints := &unstructured.Unstructured{Object: decodeYAML(t, `
+apiVersion: v1
+kind: Endpoints
+metadata:
+  name: external-db
+  namespace: payments
+`)}
+	clusterRole := &unstructured.Unstructured{Object: decodeYAML(t, `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: view
+`)}
+
+	cases := []struct {
+		name     string
+		manifest Manifest
+		obj      *unstructured.Unstructured
+		want     bool
+	}{
+		{"full backup", Manifest{}, configMap, true},
+		{"namespace included", Manifest{SnapshotMetadata: SnapshotMetadata{Filter: &ResourceFilter{IncludeNamespaces: []string{"pay*"}}}}, configMap, true},
+		{"namespace not included", Manifest{SnapshotMetadata: SnapshotMetadata{Filter: &ResourceFilter{IncludeNamespaces: []string{"web"}}}}, configMap, false},
+		{"cluster scoped with included namespaces", Manifest{SnapshotMetadata: SnapshotMetadata{Filter: &ResourceFilter{IncludeNamespaces: []string{"web"}}}}, clusterRole, false},
+		{"cluster scoped with excluded namespaces", Manifest{SnapshotMetadata: SnapshotMetadata{Filter: &ResourceFilter{ExcludeNamespaces: []string{"web"}}}}, clusterRole, true},
+		{"resource excluded", Manifest{SnapshotMetadata: SnapshotMetadata{Filter: &ResourceFilter{ExcludeResources: []string{"configmaps"}}}}, configMap, false},
+		{"label selector matches", Manifest{SnapshotMetadata: SnapshotMetadata{LabelSelector: "app=api"}}, configMap, true},
+		{"label selector does not match", Manifest{SnapshotMetadata: SnapshotMetadata{LabelSelector: "app=web"}}, configMap, false},
+		{"field selector does not match", Manifest{SnapshotMetadata: SnapshotMetadata{FieldSelector: "metadata.namespace!=payments"}}, configMap, false},
+		{"secrets skipped", Manifest{SnapshotMetadata: SnapshotMetadata{SecretsMode: SecretsSkip}}, tokenSecret, false},
+		{"ephemeral token secret", Manifest{SnapshotMetadata: SnapshotMetadata{SecretsMode: SecretsRedact, EphemeralExcluded: true}}, tokenSecret, false},
+		{"token secret with ephemeral objects", Manifest{SnapshotMetadata: SnapshotMetadata{SecretsMode: SecretsRedact}}, tokenSecret, true},
+		{"ephemeral event", Manifest{SnapshotMetadata: SnapshotMetadata{EphemeralExcluded: true}}, event, false},
+		{"ephemeral event matched by a pattern", Manifest{SnapshotMetadata: SnapshotMetadata{EphemeralExcluded: true, Filter: &ResourceFilter{IncludeResources: []string{"*"}}}}, event, false},
+		{"ephemeral event included by name", Manifest{SnapshotMetadata: SnapshotMetadata{EphemeralExcluded: true, Filter: &ResourceFilter{IncludeResources: []string{"events"}}}}, event, true},
+		{"endpoints of a service with a selector", Manifest{SnapshotMetadata: SnapshotMetadata{EphemeralExcluded: true}, SelectedServices: []string{"payments/api"}}, managedEndpoints, false},
+		{"endpoints of a service without selector", Manifest{SnapshotMetadata: SnapshotMetadata{EphemeralExcluded: true}, SelectedServices: []string{"payments/api"}}, endpoints, true},
+		{"endpoints with ephemeral objects", Manifest{SelectedServices: []string{"payments/api"}}, managedEndpoints, true},
+		{"endpoints failed", Manifest{SnapshotMetadata: SnapshotMetadata{EphemeralExcluded: true}, Failures: []Failure{{GroupVersion: "v1", Resource: "endpoints"}}}, endpoints, false},
+		{"resource failed", Manifest{Failures: []Failure{{GroupVersion: "v1", Resource: "configmaps", Namespace: "payments"}}}, configMap, false},
+		{"group version failed", Manifest{Failures: []Failure{{GroupVersion: "rbac.authorization.k8s.io/v1"}}}, clusterRole, false},
+		{"other resource failed", Manifest{Failures: []Failure{{GroupVersion: "v1", Resource: "secrets"}}}, configMap, true},
+		{"skipped for its owner", Manifest{SkippedOwned: []SkippedObject{{APIVersion: "v1", Kind: "ConfigMap", Namespace: "payments", Name: "api"}}}, configMap, false},
+	}
+	for _, c := range cases {
+		if got := c.manifest.Covers(c.obj); got != c.want {
+			t.Errorf("%s: Covers() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ResourceListsFile holds the discovery information of the backed up cluster
+	ResourceListsFile = "resource_lists.yaml"
+	// MetadataFile holds the SnapshotMetadata of a snapshot
+	MetadataFile = "metadata.yaml"
+
+	// TagPartial is the restic tag of snapshots holding a partial backup
+	TagPartial = "partial"
+	// TagClusterPrefix prefixes the name of the cluster in the restic tag of its snapshots
+	TagClusterPrefix = "cluster:"
+)
+
+// ClusterTag returns the restic tag of the snapshots of cluster
+func ClusterTag(cluster string) string {
+	return TagClusterPrefix + cluster
+}
+
+// SnapshotMetadata records how a snapshot was taken, so that the consumers of
+// a snapshot can tell whether it holds the whole cluster or only a part of it.
+type SnapshotMetadata struct {
+	// Cluster is the name of the backed up cluster
+	Cluster string `json:"cluster,omitempty"`
+	// Sanitized indicates whether server populated fields were removed
+	Sanitized bool `json:"sanitized"`
+	// SanitizeRules are the rules objects were sanitized with, if not the default profile
+	SanitizeRules *SanitizeRules `json:"sanitizeRules,omitempty"`
+	// Layout is the directory layout of dumped objects. It is empty for
+	// snapshots whose paths were derived from metadata.selfLink.
+	Layout string `json:"layout,omitempty"`
+	// SecretsMode shows how Secret data was stored
+	SecretsMode string `json:"secretsMode,omitempty"`
+	// Partial indicates that only a subset of the cluster objects was dumped
+	Partial bool `json:"partial"`
+	// LabelSelector used to list the objects
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// FieldSelector used to list the objects
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// Filter used to select namespaces and resources
+	Filter *ResourceFilter `json:"filter,omitempty"`
+	// EphemeralExcluded indicates that the EphemeralResources not named by
+	// Filter and the ephemeral Secrets were left out. Snapshots taken before
+	// they were left out have it unset.
+	EphemeralExcluded bool `json:"ephemeralExcluded,omitempty"`
+	// Owned shows which owned objects were left out for their controller
+	Owned *OwnedPolicy `json:"owned,omitempty"`
+}
+
+// Metadata returns the SnapshotMetadata recorded in snapshots taken by mgr
+func (mgr BackupManager) Metadata() SnapshotMetadata {
+	md := SnapshotMetadata{
+		Cluster:       mgr.cluster,
+		Sanitized:     mgr.sanitize,
+		Layout:        mgr.layout,
+		SecretsMode:   mgr.secretsMode,
+		LabelSelector: mgr.labelSelector,
+		FieldSelector: mgr.fieldSelector,
+
+		EphemeralExcluded: !mgr.includeEphemeral,
+	}
+	if mgr.sanitize {
+		md.SanitizeRules = mgr.sanitizeRules
+	}
+	if mgr.owned.Enabled() {
+		owned := mgr.owned
+		md.Owned = &owned
+	}
+	if mgr.filter.NamespaceFiltered() || len(mgr.filter.IncludeResources) > 0 || len(mgr.filter.ExcludeResources) > 0 {
+		filter := mgr.filter
+		md.Filter = &filter
+	}
+	md.Partial = md.Filter != nil || md.LabelSelector != "" || md.FieldSelector != ""
+	return md
+}
+
+// ReadMetadata reads the SnapshotMetadata stored in snapshotDir. Snapshots
+// taken before metadata was recorded return an empty SnapshotMetadata.
+func ReadMetadata(snapshotDir string) (*SnapshotMetadata, error) {
+	md := &SnapshotMetadata{}
+	data, err := ioutil.ReadFile(filepath.Join(snapshotDir, MetadataFile))
+	if os.IsNotExist(err) {
+		return md, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, md)
+	return md, err
+}
+
+// Options returns the Options that list the same objects as the snapshot md
+// describes. Secrets of encrypted snapshots are redacted, as the public key is
+// not recorded.
+func (md SnapshotMetadata) Options() Options {
+	opt := Options{
+		Sanitize:      md.Sanitized,
+		SanitizeRules: md.SanitizeRules,
+		LabelSelector: md.LabelSelector,
+		FieldSelector: md.FieldSelector,
+		SecretsMode:   md.SecretsMode,
+		Layout:        md.Layout,
+
+		IncludeEphemeral: !md.EphemeralExcluded,
+	}
+	if opt.SecretsMode == "" {
+		opt.SecretsMode = SecretsSkip
+	} else if opt.SecretsMode == SecretsEncrypt {
+		opt.SecretsMode = SecretsRedact
+	}
+	if opt.Layout == "" {
+		opt.Layout = LayoutHierarchical
+	}
+	if md.Filter != nil {
+		opt.Filter = *md.Filter
+	}
+	if md.Owned != nil {
+		opt.Owned = *md.Owned
+	}
+	return opt
+}
@@ -0,0 +1,204 @@
+package backup
+
+import (
+	"sort"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// OwnedPolicy selects the objects that are left out of a backup because their
+// controller is backed up too and creates them again. Resources are matched
+// like in ResourceFilter, by their plural name or by their name qualified
+// with the api group, and may be glob patterns.
+type OwnedPolicy struct {
+	// Skip leaves out the owned objects of every resource
+	Skip bool `json:"skip,omitempty"`
+	// SkipResources leaves out the owned objects of these resources, even if Skip is not set
+	SkipResources []string `json:"skipResources,omitempty"`
+	// KeepResources dumps the owned objects of these resources, even if Skip is set
+	KeepResources []string `json:"keepResources,omitempty"`
+}
+
+// Enabled reports whether any owned object may be left out
+func (p OwnedPolicy) Enabled() bool {
+	return p.Skip || len(p.SkipResources) > 0
+}
+
+func (p OwnedPolicy) skips(gv schema.GroupVersion, r metav1.APIResource) bool {
+	names := []string{r.Name}
+	if gv.Group != core.GroupName {
+		names = append(names, r.Name+"."+gv.Group)
+	}
+	if matchesAny(p.KeepResources, names) {
+		return false
+	}
+	return p.Skip || matchesAny(p.SkipResources, names)
+}
+
+// SkippedObject is an object left out of a backup, because its controller is
+// in the backup
+type SkippedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Owner is the controller the object was skipped for
+	Owner OwnerRef `json:"owner"`
+}
+
+type OwnerRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// ownedRanks orders the tasks of a backup that skips owned objects, so that
+// the built-in controllers are listed before the objects they own. Resources
+// not listed here come first.
+var ownedRanks = map[string]int{
+	"replicasets.apps":                1,
+	"replicasets.extensions":          1,
+	"jobs.batch":                      1,
+	"controllerrevisions.apps":        1,
+	"pods":                            2,
+	"endpointslices.discovery.k8s.io": 2,
+}
+
+func ownedRank(t resourceTask) int {
+	name := t.resource.Name
+	if t.gv.Group != core.GroupName {
+		name += "." + t.gv.Group
+	}
+	return ownedRanks[name]
+}
+
+// sortOwnedTasks moves the tasks of owned resources after the tasks of their
+// controllers, keeping the order of tasks of the same rank
+func sortOwnedTasks(tasks []resourceTask) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return ownedRank(tasks[i]) < ownedRank(tasks[j])
+	})
+}
+
+// ownedTracker decides which owned objects are skipped as they are listed. An
+// object whose controller was listed before it is skipped at once, the others
+// are held back until every object has been listed. Objects are identified by
+// their uid. Objects read from a sanitized snapshot have no uid, they are
+// identified by their group, kind, namespace and name.
+type ownedTracker struct {
+	uids    sets.String
+	names   sets.String
+	pending []dumpedFile
+	skipped []SkippedObject
+}
+
+func newOwnedTracker() *ownedTracker {
+	return &ownedTracker{uids: sets.NewString(), names: sets.NewString()}
+}
+
+// add records f and returns true if f is dumped now. Skipped objects and the
+// ones that have to wait for resolve return false.
+func (t *ownedTracker) add(f dumpedFile) bool {
+	if f.uid != "" {
+		t.uids.Insert(f.uid)
+	} else {
+		t.names.Insert(objectKey(f.apiVersion, f.kind, f.namespace, f.name))
+	}
+	if f.controller == nil {
+		return true
+	}
+	if t.seen(f) {
+		t.skip(f)
+	} else {
+		t.pending = append(t.pending, f)
+	}
+	return false
+}
+
+// seen reports whether the controller of f has been listed
+func (t *ownedTracker) seen(f dumpedFile) bool {
+	c := f.controller
+	return t.uids.Has(string(c.UID)) ||
+		t.names.Has(objectKey(c.APIVersion, c.Kind, f.namespace, c.Name)) ||
+		t.names.Has(objectKey(c.APIVersion, c.Kind, "", c.Name))
+}
+
+// resolve returns the held back objects whose controller was not listed, in
+// the order they were added, and every skipped object. A controller that is
+// skipped itself is still in the backup through its own controller.
+func (t *ownedTracker) resolve() ([]dumpedFile, []SkippedObject) {
+	var keep []dumpedFile
+	for _, f := range t.pending {
+		if t.seen(f) {
+			t.skip(f)
+		} else {
+			keep = append(keep, f)
+		}
+	}
+	t.pending = nil
+	return keep, t.skipped
+}
+
+// objectKey identifies an object in any version of its group
+func objectKey(apiVersion, kind, namespace, name string) string {
+	gv, _ := schema.ParseGroupVersion(apiVersion)
+	return strings.Join([]string{gv.Group, kind, namespace, name}, "/")
+}
+
+func (t *ownedTracker) skip(f dumpedFile) {
+	t.skipped = append(t.skipped, SkippedObject{
+		APIVersion: f.apiVersion,
+		Kind:       f.kind,
+		Namespace:  f.namespace,
+		Name:       f.name,
+		Owner: OwnerRef{
+			APIVersion: f.controller.APIVersion,
+			Kind:       f.controller.Kind,
+			Name:       f.controller.Name,
+		},
+	})
+}
+
+// SkipOwned leaves out the objects whose controller is among objects, as
+// policy selects them. Unlike a backup, it works on objects that have been
+// read already, i.e. from a snapshot. The order of the kept objects is kept.
+func SkipOwned(objects []*unstructured.Unstructured, policy OwnedPolicy) ([]*unstructured.Unstructured, []SkippedObject) {
+	t := newOwnedTracker()
+	for _, obj := range objects {
+		f := dumpedFile{
+			apiVersion: obj.GetAPIVersion(),
+			kind:       obj.GetKind(),
+			namespace:  obj.GetNamespace(),
+			name:       obj.GetName(),
+			uid:        string(obj.GetUID()),
+		}
+		gv, err := schema.ParseGroupVersion(f.apiVersion)
+		if err != nil {
+			continue
+		}
+		plural, _ := meta.UnsafeGuessKindToResource(gv.WithKind(f.kind))
+		if policy.skips(gv, metav1.APIResource{Name: plural.Resource, Kind: f.kind}) {
+			f.controller = metav1.GetControllerOf(obj)
+		}
+		t.add(f)
+	}
+	_, skipped := t.resolve()
+
+	names := sets.NewString()
+	for _, s := range skipped {
+		names.Insert(objectKey(s.APIVersion, s.Kind, s.Namespace, s.Name))
+	}
+	var kept []*unstructured.Unstructured
+	for _, obj := range objects {
+		if !names.Has(objectKey(obj.GetAPIVersion(), obj.GetKind(), obj.GetNamespace(), obj.GetName())) {
+			kept = append(kept, obj)
+		}
+	}
+	return kept, skipped
+}
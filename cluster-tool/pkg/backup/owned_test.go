@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	appsV1 = schema.GroupVersion{Group: "apps", Version: "v1"}
+	coreV1 = schema.GroupVersion{Version: "v1"}
+
+	deploymentsResource = metav1.APIResource{Name: "deployments", Kind: "Deployment", Namespaced: true}
+	replicaSetsResource = metav1.APIResource{Name: "replicasets", Kind: "ReplicaSet", Namespaced: true}
+	podsResource        = metav1.APIResource{Name: "pods", Kind: "Pod", Namespaced: true}
+)
+
+// ownedObjects is a Deployment with its ReplicaSet and Pod, a Pod whose
+// ReplicaSet is not in the backup and a Pod without owner. Held back objects
+// are dumped after the others.
+var ownedObjects = map[string][]string{
+	"deployments": {`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: api, namespace: payments, uid: d-1}
+`},
+	"replicasets": {`
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: api-6d4cf56db6
+  namespace: payments
+  uid: rs-1
+  ownerReferences:
+  - {apiVersion: apps/v1, kind: Deployment, name: api, uid: d-1, controller: true}
+`},
+	"pods": {`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: api-6d4cf56db6-x7k2p
+  namespace: payments
+  uid: p-1
+  ownerReferences:
+  - {apiVersion: apps/v1, kind: ReplicaSet, name: api-6d4cf56db6, uid: rs-1, controller: true}
+`, `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: worker-5b8d7c9f4-q2w3e
+  namespace: payments
+  uid: p-2
+  ownerReferences:
+  - {apiVersion: apps/v1, kind: ReplicaSet, name: worker-5b8d7c9f4, uid: rs-gone, controller: true}
+`, `
+apiVersion: v1
+kind: Pod
+metadata: {name: debug, namespace: payments, uid: p-3}
+`},
+}
+
+func TestSortOwnedTasks(t *testing.T) {
+	tasks := []resourceTask{
+		{gv: coreV1, resource: podsResource},
+		{gv: coreV1, resource: metav1.APIResource{Name: "configmaps"}},
+		{gv: appsV1, resource: replicaSetsResource},
+		{gv: appsV1, resource: deploymentsResource},
+	}
+	sortOwnedTasks(tasks)
+	var got []string
+	for _, t := range tasks {
+		got = append(got, t.resource.Name)
+	}
+	want := []string{"configmaps", "deployments", "replicasets", "pods"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks sorted to %v, want %v", got, want)
+	}
+}
+
+func TestOwnedTracker(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy OwnedPolicy
+		// reverse lists the Pods first, as a controller owned by a custom resource may be
+		reverse     bool
+		wantKept    []string
+		wantSkipped []string
+	}{
+		{
+			name:        "skip",
+			policy:      OwnedPolicy{Skip: true},
+			wantKept:    []string{"api", "debug", "worker-5b8d7c9f4-q2w3e"},
+			wantSkipped: []string{"api-6d4cf56db6", "api-6d4cf56db6-x7k2p"},
+		},
+		{
+			name:        "skip with the pods listed first",
+			policy:      OwnedPolicy{Skip: true},
+			reverse:     true,
+			wantKept:    []string{"debug", "api", "worker-5b8d7c9f4-q2w3e"},
+			wantSkipped: []string{"api-6d4cf56db6-x7k2p", "api-6d4cf56db6"},
+		},
+		{
+			name:        "keep pods",
+			policy:      OwnedPolicy{Skip: true, KeepResources: []string{"pods"}},
+			wantKept:    []string{"api", "api-6d4cf56db6-x7k2p", "worker-5b8d7c9f4-q2w3e", "debug"},
+			wantSkipped: []string{"api-6d4cf56db6"},
+		},
+		{
+			name:        "keep replicasets",
+			policy:      OwnedPolicy{Skip: true, KeepResources: []string{"replicasets.*"}},
+			wantKept:    []string{"api", "api-6d4cf56db6", "debug", "worker-5b8d7c9f4-q2w3e"},
+			wantSkipped: []string{"api-6d4cf56db6-x7k2p"},
+		},
+		{
+			name:        "skip replicasets only",
+			policy:      OwnedPolicy{SkipResources: []string{"replicasets.apps"}},
+			wantKept:    []string{"api", "api-6d4cf56db6-x7k2p", "worker-5b8d7c9f4-q2w3e", "debug"},
+			wantSkipped: []string{"api-6d4cf56db6"},
+		},
+		{
+			name:        "disabled",
+			policy:      OwnedPolicy{},
+			wantKept:    []string{"api", "api-6d4cf56db6", "api-6d4cf56db6-x7k2p", "worker-5b8d7c9f4-q2w3e", "debug"},
+			wantSkipped: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tasks := []resourceTask{
+				{gv: coreV1, resource: podsResource},
+				{gv: appsV1, resource: replicaSetsResource},
+				{gv: appsV1, resource: deploymentsResource},
+			}
+			if !c.reverse {
+				sortOwnedTasks(tasks)
+			}
+
+			mgr := BackupManager{owned: c.policy, layout: LayoutHierarchical, includeEphemeral: true}
+			owned := newOwnedTracker()
+			var kept []string
+			for _, task := range tasks {
+				for _, s := range ownedObjects[task.resource.Name] {
+					err := mgr.processItem(task.gv, task.resource, decodeYAML(t, s), func(f dumpedFile) error {
+						if owned.add(f) {
+							kept = append(kept, f.name)
+						}
+						return nil
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if !c.reverse && len(owned.pending) > 1 {
+				// only the Pod of the missing ReplicaSet waits for the end of the backup
+				t.Errorf("%d objects held back", len(owned.pending))
+			}
+
+			pending, skipped := owned.resolve()
+			for _, f := range pending {
+				kept = append(kept, f.name)
+			}
+			var skippedNames []string
+			for _, s := range skipped {
+				skippedNames = append(skippedNames, s.Name)
+			}
+			if !reflect.DeepEqual(kept, c.wantKept) {
+				t.Errorf("kept %v, want %v", kept, c.wantKept)
+			}
+			if !reflect.DeepEqual(skippedNames, c.wantSkipped) {
+				t.Errorf("skipped %v, want %v", skippedNames, c.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestSkipOwned(t *testing.T) {
+	var objects []*unstructured.Unstructured
+	for _, resource := range []string{"pods", "replicasets", "deployments"} {
+		for _, s := range ownedObjects[resource] {
+			obj := &unstructured.Unstructured{Object: decodeYAML(t, s)}
+			// sanitized snapshots hold no uids
+			unstructured.RemoveNestedField(obj.Object, "metadata", "uid")
+			objects = append(objects, obj)
+		}
+	}
+
+	kept, skipped := SkipOwned(objects, OwnedPolicy{Skip: true})
+	var keptNames, skippedNames []string
+	for _, obj := range kept {
+		keptNames = append(keptNames, obj.GetName())
+	}
+	for _, s := range skipped {
+		skippedNames = append(skippedNames, s.Name)
+	}
+	if want := []string{"worker-5b8d7c9f4-q2w3e", "debug", "api"}; !reflect.DeepEqual(keptNames, want) {
+		t.Errorf("kept %v, want %v", keptNames, want)
+	}
+	if want := []string{"api-6d4cf56db6-x7k2p", "api-6d4cf56db6"}; !reflect.DeepEqual(skippedNames, want) {
+		t.Errorf("skipped %v, want %v", skippedNames, want)
+	}
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/client-go/discovery"
+)
+
+// Failure records a group/version that could not be discovered or a resource
+// that could not be listed during a best-effort backup.
+type Failure struct {
+	GroupVersion string `json:"groupVersion"`
+	Resource     string `json:"resource,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	Error        string `json:"error"`
+}
+
+// PartialBackupError is returned by a best-effort backup if some group/versions
+// or resources were skipped. Everything else has been dumped successfully.
+type PartialBackupError struct {
+	Failures []Failure
+}
+
+func (e *PartialBackupError) Error() string {
+	return fmt.Sprintf("backup is partial, failed to backup %d group/versions or resources", len(e.Failures))
+}
+
+// IsPartialBackupError returns true if err indicates that a best-effort backup
+// skipped some group/versions or resources.
+func IsPartialBackupError(err error) bool {
+	_, ok := err.(*PartialBackupError)
+	return err != nil && ok
+}
+
+func discoveryFailures(err *discovery.ErrGroupDiscoveryFailed) []Failure {
+	failures := make([]Failure, 0, len(err.Groups))
+	for gv, gerr := range err.Groups {
+		failures = append(failures, Failure{
+			GroupVersion: gv.String(),
+			Error:        gerr.Error(),
+		})
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].GroupVersion < failures[j].GroupVersion
+	})
+	return failures
+}
+
+func taskFailure(t resourceTask, err error) Failure {
+	return Failure{
+		GroupVersion: t.gv.String(),
+		Resource:     t.resource.Name,
+		Namespace:    t.namespace,
+		Error:        err.Error(),
+	}
+}
@@ -0,0 +1,390 @@
+package backup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ProfileDefault removes the fields set by the API server and controllers
+	ProfileDefault = "default"
+	// ProfilePortable extends the default profile, so that objects can be
+	// created in another cluster. It removes the IPs, ports and bindings
+	// allocated by the cluster and leaves out ServiceAccount token Secrets.
+	ProfilePortable = "portable"
+	// ProfileNone applies no built-in rule
+	ProfileNone = "none"
+)
+
+var SanitizeProfiles = []string{ProfileDefault, ProfilePortable, ProfileNone}
+
+// sanitizeProfiles are the built-in rule sets, applied in order
+var sanitizeProfiles = map[string][]string{
+	ProfileDefault:  {defaultProfile},
+	ProfilePortable: {portableProfile, defaultProfile},
+	ProfileNone:     nil,
+}
+
+const defaultProfile = `
+rules:
+- name: server-populated-metadata
+  remove:
+  - .metadata.creationTimestamp
+  - .metadata.resourceVersion
+  - .metadata.uid
+  - .metadata.generateName
+  - .metadata.generation
+  - .metadata.selfLink
+  - .metadata.managedFields
+  - .metadata.deletionTimestamp
+  - .metadata.deletionGracePeriodSeconds
+  - .metadata.annotations['kubectl.kubernetes.io/last-applied-configuration']
+  - .metadata.annotations['controller-uid']
+  - .metadata.annotations['deployment.kubernetes.io/desired-replicas']
+  - .metadata.annotations['deployment.kubernetes.io/max-replicas']
+  - .metadata.annotations['deployment.kubernetes.io/revision']
+  - .metadata.annotations['pod-template-hash']
+  - .metadata.annotations['pv.kubernetes.io/bind-completed']
+  - .metadata.annotations['pv.kubernetes.io/bound-by-controller']
+- name: pod-spec
+  match:
+    kinds: [Pod]
+  remove:
+  - .spec.dnsPolicy
+  - .spec.nodeName
+  - .spec.terminationGracePeriodSeconds
+  - .spec.containers[*].terminationMessagePath
+  - .spec.initContainers[*].terminationMessagePath
+  patch:
+  - {op: test, path: /spec/serviceAccountName, value: default}
+  - {op: remove, path: /spec/serviceAccountName}
+- name: pod-template-spec
+  match:
+    kinds: [StatefulSet, Deployment, ReplicaSet, DaemonSet, ReplicationController, Job]
+  remove:
+  - .spec.template.spec.dnsPolicy
+  - .spec.template.spec.nodeName
+  - .spec.template.spec.terminationGracePeriodSeconds
+  - .spec.template.spec.containers[*].terminationMessagePath
+  - .spec.template.spec.initContainers[*].terminationMessagePath
+  patch:
+  - {op: test, path: /spec/template/spec/serviceAccountName, value: default}
+  - {op: remove, path: /spec/template/spec/serviceAccountName}
+- name: status
+  remove:
+  - .status
+- name: empty-annotations
+  patch:
+  - {op: test, path: /metadata/annotations, value: {}}
+  - {op: remove, path: /metadata/annotations}
+`
+
+const portableProfile = `
+rules:
+- name: service-cluster-ips
+  match:
+    apiVersions: [v1]
+    kinds: [Service]
+    # headless Services keep clusterIP None
+    fieldSelector: spec.clusterIP!=None
+  remove:
+  - .spec.clusterIP
+  - .spec.clusterIPs
+- name: service-node-ports
+  match:
+    apiVersions: [v1]
+    kinds: [Service]
+  remove:
+  - .spec.ports[*].nodePort
+  - .spec.healthCheckNodePort
+- name: pvc-binding
+  match:
+    apiVersions: [v1]
+    kinds: [PersistentVolumeClaim]
+  remove:
+  - .spec.volumeName
+  - .metadata.annotations['volume.kubernetes.io/selected-node']
+- name: pv-claim-ref
+  match:
+    apiVersions: [v1]
+    kinds: [PersistentVolume]
+  # keep the claim, so that the volume binds to the restored claim only
+  remove:
+  - .spec.claimRef.uid
+  - .spec.claimRef.resourceVersion
+- name: service-account-tokens
+  match:
+    apiVersions: [v1]
+    kinds: [Secret]
+    fieldSelector: type=kubernetes.io/service-account-token
+  drop: true
+- name: service-account-token-references
+  match:
+    apiVersions: [v1]
+    kinds: [ServiceAccount]
+  # the token controller of the target cluster adds the new tokens
+  remove:
+  - .secrets
+- name: cronjob-pod-template-spec
+  match:
+    kinds: [CronJob]
+  remove:
+  - .spec.jobTemplate.spec.template.spec.dnsPolicy
+  - .spec.jobTemplate.spec.template.spec.nodeName
+  - .spec.jobTemplate.spec.template.spec.terminationGracePeriodSeconds
+  - .spec.jobTemplate.spec.template.spec.containers[*].terminationMessagePath
+  - .spec.jobTemplate.spec.template.spec.initContainers[*].terminationMessagePath
+  patch:
+  - {op: test, path: /spec/jobTemplate/spec/template/spec/serviceAccountName, value: default}
+  - {op: remove, path: /spec/jobTemplate/spec/template/spec/serviceAccountName}
+- name: any-pod-template-spec
+  # custom resources with a pod template in spec.template
+  remove:
+  - .spec.template.spec.dnsPolicy
+  - .spec.template.spec.nodeName
+  - .spec.template.spec.terminationGracePeriodSeconds
+  - .spec.template.spec.containers[*].terminationMessagePath
+  - .spec.template.spec.initContainers[*].terminationMessagePath
+  patch:
+  - {op: test, path: /spec/template/spec/serviceAccountName, value: default}
+  - {op: remove, path: /spec/template/spec/serviceAccountName}
+`
+
+// SanitizeRules is a set of rules rewriting the objects dumped with --sanitize
+type SanitizeRules struct {
+	// Profile is the built-in profile whose rules are applied before Rules,
+	// one of default, portable or none. It defaults to default.
+	Profile string         `json:"profile,omitempty"`
+	Rules   []SanitizeRule `json:"rules"`
+}
+
+// SanitizeRule removes fields, sets fields and applies a JSON patch, in that
+// order, to the objects it matches
+type SanitizeRule struct {
+	Name  string        `json:"name,omitempty"`
+	Match SanitizeMatch `json:"match,omitempty"`
+	// Drop leaves the matching objects out, the other fields are ignored
+	Drop bool `json:"drop,omitempty"`
+	// Remove lists the paths of the fields to remove
+	Remove []string `json:"remove,omitempty"`
+	// Set lists the fields to set, missing parent fields are created
+	Set []FieldValue `json:"set,omitempty"`
+	// Patch is a JSON patch (RFC 6902). It is skipped if any of its operations
+	// fails, so test operations make it conditional.
+	Patch []PatchOperation `json:"patch,omitempty"`
+}
+
+// SanitizeMatch selects objects. Lists hold glob patterns as understood by
+// path.Match. Empty fields match every object.
+type SanitizeMatch struct {
+	APIVersions []string `json:"apiVersions,omitempty"`
+	Kinds       []string `json:"kinds,omitempty"`
+	// Namespaces never match cluster scoped objects
+	Namespaces    []string `json:"namespaces,omitempty"`
+	LabelSelector string   `json:"labelSelector,omitempty"`
+	// FieldSelector compares fields given by their dotted path with = and !=,
+	// i.e. spec.clusterIP!=None. Missing fields compare as empty.
+	FieldSelector string `json:"fieldSelector,omitempty"`
+}
+
+// FieldValue is a field to set. Path uses the same syntax as SanitizeRule.Remove.
+type FieldValue struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Sanitizer applies a set of SanitizeRules
+type Sanitizer struct {
+	rules []sanitizeRule
+}
+
+type sanitizeRule struct {
+	SanitizeRule
+	selector      labels.Selector
+	fieldSelector fields.Selector
+	remove        []*fieldPath
+	set           []*fieldPath
+}
+
+var defaultSanitizer = mustSanitizer(nil)
+
+// Sanitize removes the fields of an object that are set by the cluster, so that
+// it can be created again, as defined by the default profile. Objects are modified in place.
+func Sanitize(item map[string]interface{}) error {
+	_, err := defaultSanitizer.Sanitize(item)
+	return err
+}
+
+// LoadSanitizeRules reads and validates a YAML file holding SanitizeRules
+func LoadSanitizeRules(file string) (*SanitizeRules, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules := &SanitizeRules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, errors.Wrapf(err, "failed to read sanitize rules %s", file)
+	}
+	if _, err := NewSanitizer(rules); err != nil {
+		return nil, errors.Wrapf(err, "invalid sanitize rules %s", file)
+	}
+	return rules, nil
+}
+
+// NewSanitizer returns a Sanitizer applying the rules of their profile followed
+// by the rules themselves. Nil rules select the default profile.
+func NewSanitizer(rules *SanitizeRules) (*Sanitizer, error) {
+	if rules == nil {
+		rules = &SanitizeRules{}
+	}
+	profile := rules.Profile
+	if profile == "" {
+		profile = ProfileDefault
+	}
+	texts, ok := sanitizeProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown sanitize profile %q, must be one of %s", profile, strings.Join(SanitizeProfiles, ", "))
+	}
+	var all []SanitizeRule
+	for _, text := range texts {
+		builtin := &SanitizeRules{}
+		if err := yaml.Unmarshal([]byte(text), builtin); err != nil {
+			return nil, err
+		}
+		all = append(all, builtin.Rules...)
+	}
+
+	s := &Sanitizer{}
+	for _, r := range append(all, rules.Rules...) {
+		rule, err := compileRule(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rule %q", r.Name)
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return s, nil
+}
+
+func mustSanitizer(rules *SanitizeRules) *Sanitizer {
+	s, err := NewSanitizer(rules)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func compileRule(r SanitizeRule) (sanitizeRule, error) {
+	rule := sanitizeRule{SanitizeRule: r, selector: labels.Everything(), fieldSelector: fields.Everything()}
+	for _, patterns := range [][]string{r.Match.APIVersions, r.Match.Kinds, r.Match.Namespaces} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return rule, errors.Wrapf(err, "invalid pattern %q", p)
+			}
+		}
+	}
+	if r.Match.LabelSelector != "" {
+		var err error
+		if rule.selector, err = labels.Parse(r.Match.LabelSelector); err != nil {
+			return rule, err
+		}
+	}
+	if r.Match.FieldSelector != "" {
+		var err error
+		if rule.fieldSelector, err = fields.ParseSelector(r.Match.FieldSelector); err != nil {
+			return rule, err
+		}
+	}
+	for _, text := range r.Remove {
+		p, err := parseFieldPath(text)
+		if err != nil {
+			return rule, err
+		}
+		rule.remove = append(rule.remove, p)
+	}
+	for _, f := range r.Set {
+		p, err := parseFieldPath(f.Path)
+		if err != nil {
+			return rule, err
+		}
+		rule.set = append(rule.set, p)
+	}
+	if err := validatePatch(r.Patch); err != nil {
+		return rule, err
+	}
+	return rule, nil
+}
+
+// Sanitize applies the rules matching item in order. Objects are modified in
+// place. It returns false if a rule drops the object.
+func (s *Sanitizer) Sanitize(item map[string]interface{}) (bool, error) {
+	obj := &unstructured.Unstructured{Object: item}
+	for _, rule := range s.rules {
+		if !rule.matches(obj) {
+			continue
+		}
+		if rule.Drop {
+			glog.V(3).Infof("Dropping %s %s/%s, matched by rule %q", obj.GetKind(), obj.GetNamespace(), obj.GetName(), rule.Name)
+			return false, nil
+		}
+		for _, p := range rule.remove {
+			p.remove(item)
+		}
+		for i, p := range rule.set {
+			p.set(item, rule.Set[i].Value)
+		}
+		if len(rule.Patch) == 0 {
+			continue
+		}
+		patched, err := applyPatch(item, rule.Patch)
+		if _, ok := err.(*patchTestFailedError); ok {
+			continue
+		} else if err != nil {
+			glog.V(3).Infof("Skipping patch of rule %q for %s %s/%s: %s", rule.Name, obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+			continue
+		}
+		for k := range item {
+			delete(item, k)
+		}
+		for k, v := range patched {
+			item[k] = v
+		}
+	}
+	return true, nil
+}
+
+func (r sanitizeRule) matches(obj *unstructured.Unstructured) bool {
+	m := r.Match
+	if len(m.APIVersions) > 0 && !matchesAny(m.APIVersions, []string{obj.GetAPIVersion()}) {
+		return false
+	}
+	if len(m.Kinds) > 0 && !matchesAny(m.Kinds, []string{obj.GetKind()}) {
+		return false
+	}
+	if len(m.Namespaces) > 0 && (obj.GetNamespace() == "" || !matchesAny(m.Namespaces, []string{obj.GetNamespace()})) {
+		return false
+	}
+	if !r.selector.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	return r.fieldSelector.Matches(fieldValues(obj, r.fieldSelector))
+}
+
+// fieldValues looks up the fields selector refers to in obj
+func fieldValues(obj *unstructured.Unstructured, selector fields.Selector) fields.Set {
+	values := fields.Set{}
+	for _, req := range selector.Requirements() {
+		if v, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, strings.Split(req.Field, ".")...); ok {
+			values[req.Field] = fmt.Sprint(v)
+		}
+	}
+	return values
+}
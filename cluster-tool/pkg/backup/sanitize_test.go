@@ -0,0 +1,655 @@
+package backup
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// legacySanitize is the cleanup the default profile replaced. The decorator
+// annotations are read as map[string]interface{}: the original asserted
+// map[string]string, which never matches a decoded object, so it never
+// removed them.
+func legacySanitize(t *testing.T, item map[string]interface{}) {
+	legacyCleanUpObjectMeta(item["metadata"])
+	if spec, ok := item["spec"].(map[string]interface{}); ok {
+		switch item["kind"] {
+		case "Pod":
+			item["spec"] = legacyCleanUpPodSpec(t, spec)
+		case "StatefulSet", "Deployment", "ReplicaSet", "DaemonSet", "ReplicationController", "Job":
+			template, ok := spec["template"].(map[string]interface{})
+			if ok {
+				podSpec, ok := template["spec"].(map[string]interface{})
+				if ok {
+					template["spec"] = legacyCleanUpPodSpec(t, podSpec)
+				}
+			}
+		}
+	}
+	delete(item, "status")
+}
+
+func legacyCleanUpObjectMeta(md interface{}) {
+	meta, ok := md.(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(meta, "creationTimestamp")
+	delete(meta, "resourceVersion")
+	delete(meta, "uid")
+	delete(meta, "generateName")
+	delete(meta, "generation")
+	annotations, ok := meta["annotations"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(annotations, "controller-uid")
+	delete(annotations, "deployment.kubernetes.io/desired-replicas")
+	delete(annotations, "deployment.kubernetes.io/max-replicas")
+	delete(annotations, "deployment.kubernetes.io/revision")
+	delete(annotations, "pod-template-hash")
+	delete(annotations, "pv.kubernetes.io/bind-completed")
+	delete(annotations, "pv.kubernetes.io/bound-by-controller")
+}
+
+// legacyCleanUpPodSpec cleans the pod spec through core.PodSpec, which also
+// reformats it, i.e. adds "resources: {}" to every container
+func legacyCleanUpPodSpec(t *testing.T, in map[string]interface{}) map[string]interface{} {
+	b, err := yaml.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &core.PodSpec{}
+	if err := yaml.Unmarshal(b, spec); err != nil {
+		return in // Not a podSpec
+	}
+	spec.DNSPolicy = core.DNSPolicy("")
+	spec.NodeName = ""
+	if spec.ServiceAccountName == "default" {
+		spec.ServiceAccountName = ""
+	}
+	spec.TerminationGracePeriodSeconds = nil
+	for i, c := range spec.Containers {
+		c.TerminationMessagePath = ""
+		spec.Containers[i] = c
+	}
+	for i, c := range spec.InitContainers {
+		c.TerminationMessagePath = ""
+		spec.InitContainers[i] = c
+	}
+	b, err = yaml.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// legacyObjects hold the fields the removed cleanup handled. The annotations
+// keep a user annotation, the default profile removes empty annotations since.
+var legacyObjects = []string{`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: api-6d4cf56db6-x7k2p
+  generateName: api-6d4cf56db6-
+  namespace: payments
+  uid: 0b6f6f5e-7a1c-4d0c-9f3c-1f2a3b4c5d6e
+  resourceVersion: "48213"
+  creationTimestamp: "2019-05-02T10:00:03Z"
+  labels: {app: api, pod-template-hash: 6d4cf56db6}
+  annotations:
+    pod-template-hash: 6d4cf56db6
+    prometheus.io/scrape: "true"
+spec:
+  dnsPolicy: ClusterFirst
+  nodeName: node-1
+  serviceAccountName: default
+  serviceAccount: default
+  terminationGracePeriodSeconds: 30
+  restartPolicy: Always
+  initContainers:
+  - name: migrate
+    image: "api:1.0"
+    command: [migrate]
+    terminationMessagePath: /dev/termination-log
+    terminationMessagePolicy: File
+  containers:
+  - name: api
+    image: "api:1.0"
+    ports:
+    - {containerPort: 8080, protocol: TCP}
+    resources:
+      requests: {cpu: 100m, memory: 128Mi}
+    terminationMessagePath: /dev/termination-log
+    terminationMessagePolicy: File
+    imagePullPolicy: IfNotPresent
+status:
+  phase: Running
+  podIP: 10.0.0.12
+`, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: payments
+  uid: 7c1d2e3f-4a5b-4c6d-8e9f-0a1b2c3d4e5f
+  resourceVersion: "48190"
+  generation: 4
+  creationTimestamp: "2019-05-01T09:00:00Z"
+  annotations:
+    deployment.kubernetes.io/revision: "4"
+    team: payments
+spec:
+  replicas: 2
+  selector:
+    matchLabels: {app: api}
+  template:
+    metadata:
+      labels: {app: api}
+    spec:
+      dnsPolicy: ClusterFirst
+      serviceAccountName: api
+      terminationGracePeriodSeconds: 60
+      containers:
+      - name: api
+        image: "api:1.0"
+        terminationMessagePath: /dev/termination-log
+status:
+  replicas: 2
+  readyReplicas: 2
+`, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  namespace: payments
+  uid: 9e8d7c6b-5a4f-4e3d-2c1b-0a9f8e7d6c5b
+  resourceVersion: "1042"
+  creationTimestamp: "2019-05-01T09:00:00Z"
+spec:
+  type: ClusterIP
+  clusterIP: 10.96.12.34
+  selector: {app: api}
+  ports:
+  - {name: http, port: 80, targetPort: 8080, protocol: TCP}
+status:
+  loadBalancer: {}
+`, `
+apiVersion: v1
+kind: PersistentVolume
+metadata:
+  name: pvc-1a2b3c4d
+  uid: 5f4e3d2c-1b0a-4f9e-8d7c-6b5a4f3e2d1c
+  resourceVersion: "2210"
+  annotations:
+    pv.kubernetes.io/bound-by-controller: "yes"
+    pv.kubernetes.io/provisioned-by: kubernetes.io/gce-pd
+spec:
+  capacity: {storage: 10Gi}
+  accessModes: [ReadWriteOnce]
+  claimRef: {kind: PersistentVolumeClaim, namespace: payments, name: data-db-0, uid: 3c2b1a0f-9e8d-4c7b-6a5f-4e3d2c1b0a9f}
+status:
+  phase: Bound
+`}
+
+// TestDefaultProfileMatchesLegacyCleanup checks that the default profile
+// removes what the replaced cleanup removed. Pod specs sanitized by the profile
+// are reformatted through core.PodSpec like the legacy cleanup did.
+func TestDefaultProfileMatchesLegacyCleanup(t *testing.T) {
+	for _, text := range legacyObjects {
+		legacy := decodeYAML(t, text)
+		legacySanitize(t, legacy)
+
+		item := decodeYAML(t, text)
+		if err := Sanitize(item); err != nil {
+			t.Fatal(err)
+		}
+		if spec, ok := item["spec"].(map[string]interface{}); ok {
+			switch item["kind"] {
+			case "Pod":
+				item["spec"] = legacyCleanUpPodSpec(t, spec)
+			case "Deployment":
+				template := spec["template"].(map[string]interface{})
+				template["spec"] = legacyCleanUpPodSpec(t, template["spec"].(map[string]interface{}))
+			}
+		}
+
+		if got, want := jsonRoundTrip(t, item), jsonRoundTrip(t, legacy); !reflect.DeepEqual(got, want) {
+			gotYAML, _ := yaml.Marshal(got)
+			wantYAML, _ := yaml.Marshal(want)
+			t.Errorf("default profile gives\n%s\nlegacy cleanup gives\n%s", gotYAML, wantYAML)
+		}
+	}
+}
+
+func jsonRoundTrip(t *testing.T, v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// sanitizedObjects are objects as the API server of a recent cluster returns
+// them, with the fields the default profile removes
+var sanitizedObjects = map[string]string{
+	"Deployment": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: payments
+  uid: 7c1d2e3f-4a5b-4c6d-8e9f-0a1b2c3d4e5f
+  resourceVersion: "48190"
+  generation: 4
+  creationTimestamp: "2021-03-01T09:00:00Z"
+  selfLink: /apis/apps/v1/namespaces/payments/deployments/api
+  labels: {app: api}
+  annotations:
+    deployment.kubernetes.io/revision: "4"
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"apps/v1","kind":"Deployment","metadata":{"annotations":{},"name":"api","namespace":"payments"}}
+  managedFields:
+  - manager: kubectl-client-side-apply
+    operation: Update
+    apiVersion: apps/v1
+    time: "2021-03-01T09:00:00Z"
+    fieldsType: FieldsV1
+    fieldsV1: {"f:spec": {"f:replicas": {}}}
+  - manager: kube-controller-manager
+    operation: Update
+    apiVersion: apps/v1
+    time: "2021-03-02T10:00:00Z"
+    fieldsType: FieldsV1
+    fieldsV1: {"f:status": {"f:replicas": {}}}
+spec:
+  replicas: 2
+  selector:
+    matchLabels: {app: api}
+  template:
+    metadata:
+      labels: {app: api}
+    spec:
+      dnsPolicy: ClusterFirst
+      serviceAccountName: default
+      terminationGracePeriodSeconds: 30
+      containers:
+      - name: api
+        image: "api:1.0"
+        terminationMessagePath: /dev/termination-log
+status:
+  replicas: 2
+  readyReplicas: 2
+`,
+	"Pod": `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: api-6d4cf56db6-x7k2p
+  generateName: api-6d4cf56db6-
+  namespace: payments
+  uid: 0b6f6f5e-7a1c-4d0c-9f3c-1f2a3b4c5d6e
+  resourceVersion: "48213"
+  creationTimestamp: "2021-03-02T10:00:03Z"
+  deletionTimestamp: "2021-03-02T11:00:33Z"
+  deletionGracePeriodSeconds: 30
+  selfLink: /api/v1/namespaces/payments/pods/api-6d4cf56db6-x7k2p
+  labels: {app: api, pod-template-hash: 6d4cf56db6}
+  annotations:
+    pod-template-hash: 6d4cf56db6
+    prometheus.io/scrape: "true"
+  ownerReferences:
+  - {apiVersion: apps/v1, kind: ReplicaSet, name: api-6d4cf56db6, uid: 1a2b3c4d-0000-4000-8000-000000000001, controller: true}
+  managedFields:
+  - manager: kube-controller-manager
+    operation: Update
+    apiVersion: v1
+    time: "2021-03-02T10:00:03Z"
+    fieldsType: FieldsV1
+    fieldsV1: {"f:metadata": {"f:generateName": {}}}
+spec:
+  nodeName: node-1
+  dnsPolicy: ClusterFirst
+  serviceAccountName: api
+  terminationGracePeriodSeconds: 30
+  containers:
+  - name: api
+    image: "api:1.0"
+    terminationMessagePath: /dev/termination-log
+status:
+  phase: Running
+`,
+	"Secret": `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: payments
+  uid: 2b3c4d5e-6f70-4812-9a3b-4c5d6e7f8091
+  resourceVersion: "1201"
+  creationTimestamp: "2021-03-01T09:00:00Z"
+  selfLink: /api/v1/namespaces/payments/secrets/db
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"v1","data":{"password":"aHVudGVyMg=="},"kind":"Secret","metadata":{"annotations":{},"name":"db","namespace":"payments"},"type":"Opaque"}
+  managedFields:
+  - manager: kubectl-client-side-apply
+    operation: Update
+    apiVersion: v1
+    time: "2021-03-01T09:00:00Z"
+    fieldsType: FieldsV1
+    fieldsV1: {"f:data": {"f:password": {}}}
+type: Opaque
+data:
+  password: aHVudGVyMg==
+`,
+	"Service": `
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  namespace: payments
+  uid: 9e8d7c6b-5a4f-4e3d-2c1b-0a9f8e7d6c5b
+  resourceVersion: "1042"
+  creationTimestamp: "2021-03-01T09:00:00Z"
+  selfLink: /api/v1/namespaces/payments/services/api
+  labels: {app: api}
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"v1","kind":"Service","metadata":{"annotations":{},"name":"api","namespace":"payments"}}
+  managedFields:
+  - manager: kubectl-client-side-apply
+    operation: Update
+    apiVersion: v1
+    time: "2021-03-01T09:00:00Z"
+    fieldsType: FieldsV1
+    fieldsV1: {"f:spec": {"f:ports": {}}}
+spec:
+  type: NodePort
+  clusterIP: 10.96.12.34
+  selector: {app: api}
+  ports:
+  - {name: http, port: 80, targetPort: 8080, nodePort: 30080, protocol: TCP}
+status:
+  loadBalancer: {}
+`,
+}
+
+func TestSanitizeDefaultProfile(t *testing.T) {
+	cases := []struct {
+		kind    string
+		removed []string
+		kept    []string
+	}{
+		{
+			kind: "Deployment",
+			removed: []string{
+				"metadata.uid", "metadata.resourceVersion", "metadata.generation", "metadata.creationTimestamp",
+				"metadata.selfLink", "metadata.managedFields", "metadata.annotations", "status",
+				"spec.template.spec.dnsPolicy", "spec.template.spec.serviceAccountName",
+				"spec.template.spec.terminationGracePeriodSeconds",
+			},
+			kept: []string{"metadata.name", "metadata.labels", "spec.replicas", "spec.template.spec.containers"},
+		},
+		{
+			kind: "Pod",
+			removed: []string{
+				"metadata.uid", "metadata.generateName", "metadata.selfLink", "metadata.managedFields",
+				"metadata.deletionTimestamp", "metadata.deletionGracePeriodSeconds",
+				"metadata.annotations.pod-template-hash", "status",
+				"spec.nodeName", "spec.dnsPolicy", "spec.terminationGracePeriodSeconds",
+			},
+			kept: []string{"metadata.annotations.prometheus\\.io/scrape", "metadata.ownerReferences", "spec.serviceAccountName"},
+		},
+		{
+			kind: "Secret",
+			removed: []string{
+				"metadata.uid", "metadata.resourceVersion", "metadata.selfLink", "metadata.managedFields", "metadata.annotations",
+			},
+			kept: []string{"type", "data.password"},
+		},
+		{
+			kind: "Service",
+			removed: []string{
+				"metadata.uid", "metadata.selfLink", "metadata.managedFields", "metadata.annotations", "status",
+			},
+			// the cluster IPs and node ports are removed by the portable profile only
+			kept: []string{"spec.clusterIP", "spec.selector", "spec.ports"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.kind, func(t *testing.T) {
+			item := decodeYAML(t, sanitizedObjects[c.kind])
+			if err := Sanitize(item); err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range c.removed {
+				if _, found, _ := unstructured.NestedFieldNoCopy(item, splitField(field)...); found {
+					t.Errorf("%s was kept", field)
+				}
+			}
+			for _, field := range c.kept {
+				if _, found, _ := unstructured.NestedFieldNoCopy(item, splitField(field)...); !found {
+					t.Errorf("%s was removed", field)
+				}
+			}
+			if c.kind == "Pod" {
+				containers := item["spec"].(map[string]interface{})["containers"].([]interface{})
+				if _, found := containers[0].(map[string]interface{})["terminationMessagePath"]; found {
+					t.Error("terminationMessagePath of the container was kept")
+				}
+			}
+		})
+	}
+}
+
+// TestSanitizeDecoratorAnnotations checks that the annotations of decoded
+// objects, which are map[string]interface{}, are cleaned up
+func TestSanitizeDecoratorAnnotations(t *testing.T) {
+	item := decodeYAML(t, `
+apiVersion: v1
+kind: PersistentVolume
+metadata:
+  name: pvc-1a2b3c4d
+  annotations:
+    pv.kubernetes.io/bound-by-controller: "yes"
+    pv.kubernetes.io/bind-completed: "yes"
+    controller-uid: 3c2b1a0f-9e8d-4c7b-6a5f-4e3d2c1b0a9f
+`)
+	if err := Sanitize(item); err != nil {
+		t.Fatal(err)
+	}
+	if md := item["metadata"].(map[string]interface{}); md["annotations"] != nil {
+		t.Errorf("decorator annotations were kept: %v", md["annotations"])
+	}
+}
+
+// splitField splits a dotted path, a backslash escapes a dot
+func splitField(path string) []string {
+	var fields []string
+	var cur strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			cur.WriteByte(path[i])
+		case path[i] == '.':
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(path[i])
+		}
+	}
+	return append(fields, cur.String())
+}
+
+func TestSanitizePortableProfile(t *testing.T) {
+	s, err := NewSanitizer(&SanitizeRules{Profile: ProfilePortable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name      string
+		object    string
+		dropped   bool
+		removed   []string
+		kept      []string
+		clusterIP string
+	}{
+		{
+			name:    "service",
+			object:  sanitizedObjects["Service"],
+			removed: []string{"spec.clusterIP", "metadata.uid", "status"},
+			kept:    []string{"spec.selector", "spec.ports"},
+		},
+		{
+			name: "headless service",
+			object: `
+apiVersion: v1
+kind: Service
+metadata: {name: db, namespace: payments, uid: 4d5e6f70-8192-4a3b-9c5d-6e7f8091a2b3}
+spec:
+  clusterIP: None
+  selector: {app: db}
+  ports:
+  - {port: 5432, protocol: TCP}
+`,
+			removed:   []string{"metadata.uid"},
+			clusterIP: "None",
+		},
+		{
+			name: "token secret",
+			object: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: api-token-x7k2p
+  namespace: payments
+  annotations: {kubernetes.io/service-account.name: api}
+type: kubernetes.io/service-account-token
+data: {token: ZXlKaGJHY2lPaUpTVXpJMU5pSXNJbXRwWkNJNklpSjk=}
+`,
+			dropped: true,
+		},
+		{
+			name:   "opaque secret",
+			object: sanitizedObjects["Secret"],
+			kept:   []string{"data.password"},
+		},
+		{
+			name: "service account",
+			object: `
+apiVersion: v1
+kind: ServiceAccount
+metadata: {name: api, namespace: payments}
+secrets:
+- name: api-token-x7k2p
+`,
+			removed: []string{"secrets"},
+		},
+		{
+			name: "cronjob",
+			object: `
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata: {name: report, namespace: payments}
+spec:
+  schedule: "0 3 * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          dnsPolicy: ClusterFirst
+          nodeName: node-1
+          serviceAccountName: default
+          terminationGracePeriodSeconds: 30
+          restartPolicy: OnFailure
+          containers:
+          - name: report
+            image: "report:1.0"
+            terminationMessagePath: /dev/termination-log
+status: {}
+`,
+			removed: []string{
+				"status",
+				"spec.jobTemplate.spec.template.spec.dnsPolicy",
+				"spec.jobTemplate.spec.template.spec.nodeName",
+				"spec.jobTemplate.spec.template.spec.serviceAccountName",
+				"spec.jobTemplate.spec.template.spec.terminationGracePeriodSeconds",
+			},
+			kept: []string{"spec.schedule", "spec.jobTemplate.spec.template.spec.restartPolicy"},
+		},
+		{
+			name: "custom resource with a pod template",
+			object: `
+apiVersion: example.com/v1
+kind: Worker
+metadata: {name: queue, namespace: payments}
+spec:
+  template:
+    spec:
+      dnsPolicy: ClusterFirst
+      nodeName: node-1
+      serviceAccountName: default
+      terminationGracePeriodSeconds: 30
+      containers:
+      - name: worker
+        image: "worker:1.0"
+        terminationMessagePath: /dev/termination-log
+`,
+			removed: []string{
+				"spec.template.spec.dnsPolicy",
+				"spec.template.spec.nodeName",
+				"spec.template.spec.serviceAccountName",
+				"spec.template.spec.terminationGracePeriodSeconds",
+			},
+			kept: []string{"spec.template.spec.containers"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			item := decodeYAML(t, c.object)
+			keep, err := s.Sanitize(item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keep == c.dropped {
+				t.Fatalf("Sanitize() = %v, want %v", keep, !c.dropped)
+			}
+			for _, field := range c.removed {
+				if _, found, _ := unstructured.NestedFieldNoCopy(item, splitField(field)...); found {
+					t.Errorf("%s was kept", field)
+				}
+			}
+			for _, field := range c.kept {
+				if _, found, _ := unstructured.NestedFieldNoCopy(item, splitField(field)...); !found {
+					t.Errorf("%s was removed", field)
+				}
+			}
+			if c.clusterIP != "" {
+				if ip, _, _ := unstructured.NestedString(item, "spec", "clusterIP"); ip != c.clusterIP {
+					t.Errorf("clusterIP = %q, want %q", ip, c.clusterIP)
+				}
+			}
+			if keep {
+				data, _ := yaml.Marshal(item)
+				if strings.Contains(string(data), "terminationMessagePath") || strings.Contains(string(data), "nodePort") {
+					t.Errorf("sanitized object holds fields allocated by the cluster:\n%s", data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SecretsSkip does not dump Secrets at all
+	SecretsSkip = "skip"
+	// SecretsRedact dumps Secrets with their keys but blank values
+	SecretsRedact = "redact"
+	// SecretsEncrypt dumps Secrets with their values encrypted for a X25519 public key
+	SecretsEncrypt = "encrypt"
+
+	// SecretDataAnnotation is set on dumped Secrets whose data is not stored as is.
+	// Its value is either "redacted" or "encrypted".
+	SecretDataAnnotation = "cluster-tool.appscode.com/secret-data"
+	SecretDataRedacted   = "redacted"
+	SecretDataEncrypted  = "encrypted"
+
+	encryptedValuePrefix = "enc:v1:"
+
+	// lastAppliedAnnotation is set by kubectl apply and holds every Secret value in plain text
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+var SecretsModes = []string{SecretsSkip, SecretsRedact, SecretsEncrypt}
+
+func ValidateSecretsMode(mode string, publicKey *ecdh.PublicKey) error {
+	switch mode {
+	case SecretsSkip, SecretsRedact:
+		return nil
+	case SecretsEncrypt:
+		if publicKey == nil {
+			return errors.New("a public key is required to encrypt secrets")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown secrets mode %q, must be one of %s", mode, strings.Join(SecretsModes, ", "))
+}
+
+// ParsePublicKey parses a base64 encoded raw X25519 public key.
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// ReadPrivateKey reads a X25519 private key from file. The file may hold either
+// a PKCS #8 PEM block (as generated by "openssl genpkey -algorithm x25519") or
+// a base64 encoded raw key.
+func ReadPrivateKey(file string) (*ecdh.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pk, ok := key.(*ecdh.PrivateKey)
+		if !ok || pk.Curve() != ecdh.X25519() {
+			return nil, errors.New("private key is not a X25519 key")
+		}
+		return pk, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid private key")
+	}
+	return ecdh.X25519().NewPrivateKey(b)
+}
+
+// processSecret applies the secrets mode to a dumped Secret. It returns false
+// if the Secret must not be dumped. The last applied configuration of kubectl
+// holds the values too, it is removed with SecretsRedact and encrypted with
+// SecretsEncrypt, whether objects are sanitized or not.
+func (mgr BackupManager) processSecret(item map[string]interface{}) (bool, error) {
+	switch mgr.secretsMode {
+	case SecretsRedact:
+		for _, field := range []string{"data", "stringData"} {
+			if data, ok := item[field].(map[string]interface{}); ok {
+				for k := range data {
+					data[k] = ""
+				}
+			}
+		}
+		removeAnnotation(item, lastAppliedAnnotation)
+		setAnnotation(item, SecretDataAnnotation, SecretDataRedacted)
+		return true, nil
+	case SecretsEncrypt:
+		for _, field := range []string{"data", "stringData"} {
+			if data, ok := item[field].(map[string]interface{}); ok {
+				for k, v := range data {
+					s, _ := v.(string)
+					enc, err := encryptValue(mgr.secretsPublicKey, []byte(s))
+					if err != nil {
+						return false, err
+					}
+					data[k] = enc
+				}
+			}
+		}
+		if v := getAnnotation(item, lastAppliedAnnotation); v != "" {
+			enc, err := encryptValue(mgr.secretsPublicKey, []byte(v))
+			if err != nil {
+				return false, err
+			}
+			setAnnotation(item, lastAppliedAnnotation, enc)
+		}
+		setAnnotation(item, SecretDataAnnotation, SecretDataEncrypted)
+		return true, nil
+	}
+	return false, nil
+}
+
+// DecryptSecret decrypts the values of a Secret dumped with SecretsEncrypt in
+// place. Secrets that were not encrypted are left untouched.
+func DecryptSecret(item map[string]interface{}, key *ecdh.PrivateKey) error {
+	if getAnnotation(item, SecretDataAnnotation) != SecretDataEncrypted {
+		return nil
+	}
+	if key == nil {
+		return errors.New("a private key is required to decrypt secrets")
+	}
+	for _, field := range []string{"data", "stringData"} {
+		if data, ok := item[field].(map[string]interface{}); ok {
+			for k, v := range data {
+				s, _ := v.(string)
+				dec, err := decryptValue(key, s)
+				if err != nil {
+					return errors.Wrapf(err, "failed to decrypt key %s", k)
+				}
+				data[k] = string(dec)
+			}
+		}
+	}
+	if v := getAnnotation(item, lastAppliedAnnotation); strings.HasPrefix(v, encryptedValuePrefix) {
+		dec, err := decryptValue(key, v)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt annotation %s", lastAppliedAnnotation)
+		}
+		setAnnotation(item, lastAppliedAnnotation, string(dec))
+	}
+	removeAnnotation(item, SecretDataAnnotation)
+	return nil
+}
+
+// IsRedactedSecret reports whether item is a Secret dumped with SecretsRedact.
+func IsRedactedSecret(item map[string]interface{}) bool {
+	return getAnnotation(item, SecretDataAnnotation) == SecretDataRedacted
+}
+
+// IsEncryptedSecret reports whether item is a Secret dumped with SecretsEncrypt.
+func IsEncryptedSecret(item map[string]interface{}) bool {
+	return getAnnotation(item, SecretDataAnnotation) == SecretDataEncrypted
+}
+
+// encryptValue seals plaintext for the recipient public key. The scheme is
+// specific to cluster-tool, it is not the age format and age tools cannot
+// decrypt it. An encrypted value is
+//
+//	"enc:v1:" + base64(ephemeral public key (32 bytes) || nonce (12 bytes) || ciphertext and tag)
+//
+// where a fresh ephemeral X25519 key is generated for every value, the shared
+// secret is X25519(ephemeral private key, recipient public key) and plaintext
+// is sealed with AES-256-GCM, without additional data, under the key
+// SHA-256(shared secret || ephemeral public key || recipient public key).
+func encryptValue(recipient *ecdh.PublicKey, plaintext []byte) (string, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	out := append([]byte{}, ephemeral.PublicKey().Bytes()...)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, plaintext, nil)
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+func decryptValue(key *ecdh.PrivateKey, value string) ([]byte, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return nil, errors.New("value is not encrypted")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedValuePrefix))
+	if err != nil {
+		return nil, err
+	}
+	const keySize = 32
+	if len(b) < keySize {
+		return nil, errors.New("encrypted value is too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(b[:keySize])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(shared, ephemeral, key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	b = b[keySize:]
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	return aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+}
+
+func newAEAD(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeral.Bytes())
+	h.Write(recipient.Bytes())
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func getAnnotation(item map[string]interface{}, key string) string {
+	md, _ := item["metadata"].(map[string]interface{})
+	annotations, _ := md["annotations"].(map[string]interface{})
+	v, _ := annotations[key].(string)
+	return v
+}
+
+func setAnnotation(item map[string]interface{}, key, value string) {
+	md, ok := item["metadata"].(map[string]interface{})
+	if !ok {
+		md = map[string]interface{}{}
+		item["metadata"] = md
+	}
+	annotations, ok := md["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = map[string]interface{}{}
+		md["annotations"] = annotations
+	}
+	annotations[key] = value
+}
+
+func removeAnnotation(item map[string]interface{}, key string) {
+	md, _ := item["metadata"].(map[string]interface{})
+	annotations, ok := md["annotations"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(annotations, key)
+	if len(annotations) == 0 {
+		delete(md, "annotations")
+	}
+}
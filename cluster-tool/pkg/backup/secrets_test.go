@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+const appliedSecret = `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: payments
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"v1","data":{"password":"aHVudGVyMg=="},"kind":"Secret","metadata":{"annotations":{},"name":"db","namespace":"payments"},"type":"Opaque"}
+type: Opaque
+data:
+  password: aHVudGVyMg==
+`
+
+func decodeYAML(t *testing.T, s string) map[string]interface{} {
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(s), &obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestProcessSecretRedact(t *testing.T) {
+	item := decodeYAML(t, appliedSecret)
+	mgr := BackupManager{secretsMode: SecretsRedact}
+	if ok, err := mgr.processSecret(item); err != nil || !ok {
+		t.Fatalf("processSecret() = %v, %v", ok, err)
+	}
+
+	data, err := yaml.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "aHVudGVyMg==") {
+		t.Errorf("redacted Secret holds the value:\n%s", data)
+	}
+	if v := getAnnotation(item, lastAppliedAnnotation); v != "" {
+		t.Errorf("last applied configuration was kept: %s", v)
+	}
+	if !IsRedactedSecret(item) {
+		t.Error("Secret is not marked as redacted")
+	}
+}
+
+func TestProcessSecretEncrypt(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := decodeYAML(t, appliedSecret)
+	lastApplied := getAnnotation(item, lastAppliedAnnotation)
+	mgr := BackupManager{secretsMode: SecretsEncrypt, secretsPublicKey: key.PublicKey()}
+	if ok, err := mgr.processSecret(item); err != nil || !ok {
+		t.Fatalf("processSecret() = %v, %v", ok, err)
+	}
+
+	data, err := yaml.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "aHVudGVyMg==") {
+		t.Errorf("encrypted Secret holds the value:\n%s", data)
+	}
+	if v := getAnnotation(item, lastAppliedAnnotation); !strings.HasPrefix(v, encryptedValuePrefix) {
+		t.Errorf("last applied configuration is not encrypted: %s", v)
+	}
+
+	if err := DecryptSecret(item, key); err != nil {
+		t.Fatal(err)
+	}
+	if v := item["data"].(map[string]interface{})["password"]; v != "aHVudGVyMg==" {
+		t.Errorf("decrypted password = %v", v)
+	}
+	if v := getAnnotation(item, lastAppliedAnnotation); v != lastApplied {
+		t.Errorf("decrypted last applied configuration = %s, want %s", v, lastApplied)
+	}
+	if IsEncryptedSecret(item) {
+		t.Error("decrypted Secret is still marked as encrypted")
+	}
+}
+
+func TestDecryptValueWrongKey(t *testing.T) {
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	enc, err := encryptValue(key.PublicKey(), []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptValue(other, enc); err == nil {
+		t.Error("decrypting with another key succeeded")
+	}
+	dec, err := decryptValue(key, enc)
+	if err != nil || string(dec) != "hunter2" {
+		t.Errorf("decryptValue() = %q, %v", dec, err)
+	}
+}
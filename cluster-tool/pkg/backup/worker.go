@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// filesPerTask is the number of dumped files a worker may buffer while it
+// waits for the tasks before it to be written.
+const filesPerTask = 64
+
+var errAborted = errors.New("backup aborted")
+
+// resourceTask lists the objects of a resource in a namespace. An empty
+// namespace lists the objects of all namespaces.
+type resourceTask struct {
+	gv        schema.GroupVersion
+	resource  metav1.APIResource
+	namespace string
+	// fieldSelector is passed to the list calls along with the one of the backup
+	fieldSelector string
+	// skip holds the namespace/name keys of the objects left out
+	skip sets.String
+}
+
+// dumpedFile is a dumped object along with its identity
+type dumpedFile struct {
+	relPath string
+	data    []byte
+
+	apiVersion string
+	kind       string
+	namespace  string
+	name       string
+
+	uid string
+	// controller is set if the object may be skipped for its controller
+	controller *metav1.OwnerReference
+}
+
+type emitFunc func(f dumpedFile) error
+
+// runTasks runs tasks on mgr.concurrency workers. The dumped files are passed
+// to emit from a single goroutine in task order, so the output does not
+// depend on which worker finishes first. In best-effort mode failed tasks are
+// returned as failures instead of stopping the backup.
+func (mgr BackupManager) runTasks(tasks []resourceTask, emit emitFunc) ([]Failure, error) {
+	concurrency := mgr.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	files := make([]chan dumpedFile, len(tasks))
+	errs := make([]error, len(tasks))
+	for i := range tasks {
+		files[i] = make(chan dumpedFile, filesPerTask)
+	}
+	done := make(chan struct{})
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		for i := range tasks {
+			select {
+			case queue <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				errs[i] = mgr.runTask(tasks[i], files[i], done)
+				close(files[i])
+			}
+		}()
+	}
+
+	var failures []Failure
+	var err error
+	for i := range tasks {
+		for f := range files[i] {
+			if err == nil {
+				err = emit(f)
+			}
+		}
+		if err == nil && errs[i] != nil {
+			if mgr.bestEffort {
+				glog.Warningf("Skipping %s apiVersion:%s kind:%s namespace:%s, failed to backup: %v", tasks[i].gv, tasks[i].resource.Name, tasks[i].resource.Kind, tasks[i].namespace, errs[i])
+				failures = append(failures, taskFailure(tasks[i], errs[i]))
+			} else {
+				err = errs[i]
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+	return failures, err
+}
+
+func (mgr BackupManager) runTask(t resourceTask, out chan<- dumpedFile, done <-chan struct{}) error {
+	glog.V(3).Infof("Taking backup of %s apiVersion:%s kind:%s namespace:%s", t.gv, t.resource.Name, t.resource.Kind, t.namespace)
+	client, err := mgr.restClientFor(t.gv)
+	if err != nil {
+		return err
+	}
+	return mgr.backupResource(client, t, func(f dumpedFile) error {
+		select {
+		case out <- f:
+			return nil
+		case <-done:
+			return errAborted
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamic "k8s.io/client-go/deprecated-dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// namespaceServer serves two ConfigMaps in every namespace. The namespaces
+// named "slow-*" answer after a delay and the ones named "fail-*" are forbidden.
+func namespaceServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /api/v1/namespaces/<ns>/configmaps
+		ns := strings.Split(r.URL.Path, "/")[4]
+		if strings.HasPrefix(ns, "slow-") {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if strings.HasPrefix(ns, "fail-") {
+			writeStatus(w, metav1.Status{Code: http.StatusForbidden, Reason: metav1.StatusReasonForbidden, Message: "configmaps is forbidden"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"kind":"ConfigMapList","apiVersion":"v1","metadata":{},"items":[
+			{"metadata":{"namespace":%q,"name":"1"}},{"metadata":{"namespace":%[1]q,"name":"2"}}]}`, ns)
+	}))
+}
+
+func namespaceTasks(namespaces ...string) []resourceTask {
+	var tasks []resourceTask
+	for _, ns := range namespaces {
+		tasks = append(tasks, resourceTask{
+			gv:        schema.GroupVersion{Version: "v1"},
+			resource:  metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+			namespace: ns,
+		})
+	}
+	return tasks
+}
+
+func TestRunTasks(t *testing.T) {
+	srv := namespaceServer(t)
+	defer srv.Close()
+	config := &rest.Config{Host: srv.URL}
+	if err := rest.SetKubernetesDefaults(config); err != nil {
+		t.Fatal(err)
+	}
+	config.ContentConfig = dynamic.ContentConfig()
+	errEmit := errors.New("disk full")
+
+	cases := []struct {
+		name       string
+		namespaces []string
+		bestEffort bool
+		// emitErrAt fails the n-th call of emit, starting at 1
+		emitErrAt    int
+		want         []string
+		wantFailures []string
+		wantErr      string
+	}{
+		{
+			name:       "tasks finishing out of order",
+			namespaces: []string{"slow-a", "slow-b", "c", "d", "e"},
+			want:       []string{"slow-a/1", "slow-a/2", "slow-b/1", "slow-b/2", "c/1", "c/2", "d/1", "d/2", "e/1", "e/2"},
+		},
+		{
+			name:       "failed task",
+			namespaces: []string{"slow-a", "fail-b", "c"},
+			want:       []string{"slow-a/1", "slow-a/2"},
+			wantErr:    "forbidden",
+		},
+		{
+			name:         "failed task with best effort",
+			namespaces:   []string{"slow-a", "fail-b", "c", "fail-d"},
+			bestEffort:   true,
+			want:         []string{"slow-a/1", "slow-a/2", "c/1", "c/2"},
+			wantFailures: []string{"fail-b", "fail-d"},
+		},
+		{
+			name:       "emit failure aborts the other tasks",
+			namespaces: []string{"slow-a", "b", "c", "d", "e", "f"},
+			emitErrAt:  3,
+			want:       []string{"slow-a/1", "slow-a/2"},
+			wantErr:    errEmit.Error(),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mgr := NewBackupManager("", config, Options{Concurrency: 4, BestEffort: c.bestEffort, Layout: LayoutHierarchical})
+			var got []string
+			failures, err := mgr.runTasks(namespaceTasks(c.namespaces...), func(f dumpedFile) error {
+				if len(got)+1 == c.emitErrAt {
+					return errEmit
+				}
+				got = append(got, f.namespace+"/"+f.name)
+				return nil
+			})
+			if c.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+				t.Fatalf("runTasks() = %v, want an error containing %q", err, c.wantErr)
+			}
+			if err == errAborted {
+				t.Error("runTasks() returned the abort of the other tasks")
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("emitted %v, want %v", got, c.want)
+			}
+			var failed []string
+			for _, f := range failures {
+				failed = append(failed, f.Namespace)
+			}
+			if !reflect.DeepEqual(failed, c.wantFailures) {
+				t.Errorf("failed namespaces %v, want %v", failed, c.wantFailures)
+			}
+		})
+	}
+}
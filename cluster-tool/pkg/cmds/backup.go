@@ -1,20 +1,32 @@
 package cmds
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/appscode/go/flags"
-	"github.com/appscode/kutil/tools/backup"
-	"github.com/appscode/kutil/tools/restic"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type options struct {
 	masterUrl      string
 	kubeconfigPath string
 	context        string
-	sanitize       bool
 	backupDir      string
+	manager        backup.Options
+	publicKey      string
+	sanitizeRules  string
+	profile        string
 	backup         restic.BackupOptions
 	metrics        restic.MetricsOptions
 }
@@ -27,6 +39,13 @@ func NewCmdBackup() *cobra.Command {
 
 	opt := options{
 		backupDir: "/tmp/restic/backup",
+		manager: backup.Options{
+			SecretsMode: backup.SecretsSkip,
+			ChunkSize:   500,
+			Concurrency: 1,
+			ToolVersion: Version,
+			Layout:      backup.LayoutHierarchical,
+		},
 		backup: restic.BackupOptions{
 			ScratchDir:  "/tmp/restic/scratch",
 			EnableCache: false,
@@ -36,49 +55,112 @@ func NewCmdBackup() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:               "backup",
 		Short:             "Takes a backup YAMLs of Kubernetes api objects",
+		Long:              backupLong(),
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir", "retention-policy.policy", "retention-policy.value")
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			if err := opt.backup.RetentionPolicy.Validate(); err != nil {
+				return err
+			}
+			if _, err := labels.Parse(opt.manager.LabelSelector); err != nil {
+				return err
+			}
+			if _, err := fields.ParseSelector(opt.manager.FieldSelector); err != nil {
+				return err
+			}
+			if opt.publicKey != "" {
+				key, err := backup.ParsePublicKey(opt.publicKey)
+				if err != nil {
+					return err
+				}
+				opt.manager.SecretsPublicKey = key
+			}
+			if opt.sanitizeRules != "" {
+				rules, err := backup.LoadSanitizeRules(opt.sanitizeRules)
+				if err != nil {
+					return err
+				}
+				opt.manager.Sanitize = true
+				opt.manager.SanitizeRules = rules
+			}
+			if cmd.Flags().Changed("sanitize-profile") {
+				rules := opt.manager.SanitizeRules
+				if rules == nil {
+					rules = &backup.SanitizeRules{}
+				}
+				if rules.Profile != "" && rules.Profile != opt.profile {
+					return fmt.Errorf("--sanitize-profile %s disagrees with profile %s of %s", opt.profile, rules.Profile, opt.sanitizeRules)
+				}
+				rules.Profile = opt.profile
+				if _, err := backup.NewSanitizer(rules); err != nil {
+					return err
+				}
+				opt.manager.Sanitize = true
+				opt.manager.SanitizeRules = rules
+			}
+			if err := backup.ValidateLayout(opt.manager.Layout); err != nil {
+				return err
+			}
+			if err := backup.ValidateSecretsMode(opt.manager.SecretsMode, opt.manager.SecretsPublicKey); err != nil {
+				return err
+			}
 
 			// Run backup
-			backupOutput, backupErr := runBackup(&opt.backup, opt.masterUrl, opt.kubeconfigPath, opt.context, opt.backupDir, opt.sanitize)
+			backupOutput, backupErr := runBackup(&opt.backup, opt.masterUrl, opt.kubeconfigPath, opt.context, opt.backupDir, opt.manager)
+
+			// A partial backup has still been stored in the repository, so its output and metrics are reported as usual
+			reportErr := backupErr
+			if backup.IsPartialBackupError(backupErr) {
+				reportErr = nil
+			}
 
 			// If metrics are enabled then generate metrics
 			if opt.metrics.Enabled {
-				err := opt.metrics.HandleMetrics(backupOutput, backupErr, JobClusterTools)
+				err := opt.metrics.HandleMetrics(backupOutput, reportErr, JobClusterTools)
 				if err != nil {
 					return errors.NewAggregate([]error{backupErr, err})
 				}
 			}
 
 			// If output directory specified, then write the output in "output.json" file in the specified directory
-			if backupErr == nil && opt.backup.OutputDir != "" {
+			if reportErr == nil && opt.backup.OutputDir != "" {
 				err := restic.WriteOutput(backupOutput, opt.backup.OutputDir)
 				if err != nil {
 					return err
 				}
 			}
+			if backupErr == nil {
+				log.Infoln("Backup Successful")
+			}
 			return backupErr
 		},
 	}
-	cmd.Flags().StringVar(&opt.masterUrl, "master-url", "", "URL of master node")
-	cmd.Flags().StringVar(&opt.kubeconfigPath, "kubeconfig", opt.kubeconfigPath, "kubeconfig file pointing at the 'core' kubernetes server")
-	cmd.Flags().StringVar(&opt.context, "context", "", "Context to use from kubeconfig file")
-	cmd.Flags().BoolVar(&opt.sanitize, "sanitize", false, " Sanitize YAML files")
+	addKubeFlags(cmd.Flags(), &opt.masterUrl, &opt.kubeconfigPath, &opt.context)
+	cmd.Flags().BoolVar(&opt.manager.Sanitize, "sanitize", false, " Sanitize YAML files")
+	cmd.Flags().StringVar(&opt.sanitizeRules, "sanitize-rules", "", "YAML file with the rules to sanitize objects with, instead of the built-in default profile (implies --sanitize)")
+	cmd.Flags().StringVar(&opt.profile, "sanitize-profile", backup.ProfileDefault, "Built-in rules to sanitize objects with: default (fields set by the cluster), portable (also IPs, ports and volume bindings allocated by the cluster and ServiceAccount tokens, to restore into another cluster) or none (only the rules of the --sanitize-rules file). Setting any profile implies --sanitize and fails if the --sanitize-rules file sets another profile")
+	cmd.Flags().StringSliceVar(&opt.manager.Filter.IncludeNamespaces, "include-namespaces", nil, "Namespaces to backup, glob patterns are allowed (cluster scoped resources are skipped when set)")
+	cmd.Flags().StringSliceVar(&opt.manager.Filter.ExcludeNamespaces, "exclude-namespaces", nil, "Namespaces to skip, glob patterns are allowed (cluster scoped resources are still backed up)")
+	cmd.Flags().StringSliceVar(&opt.manager.Filter.IncludeResources, "include-resources", nil, "Resources to backup as <resource> or <resource>.<group>, glob patterns are allowed (i.e. deployments.apps, *.cert-manager.io)")
+	cmd.Flags().StringSliceVar(&opt.manager.Filter.ExcludeResources, "exclude-resources", nil, "Resources to skip as <resource> or <resource>.<group>, glob patterns are allowed")
+	cmd.Flags().BoolVar(&opt.manager.IncludeEphemeral, "include-ephemeral", false, "Also backup the ephemeral resources and Secrets listed above, which are skipped by default")
+	cmd.Flags().BoolVar(&opt.manager.Owned.Skip, "skip-owned", false, "Skip the objects whose controller is in the backup too (i.e. Pods of a ReplicaSet, ReplicaSets of a Deployment), the skipped objects are listed in the manifest. Objects owned by custom resources may be listed before their controller, they are held in memory until every resource has been listed.")
+	cmd.Flags().StringSliceVar(&opt.manager.Owned.SkipResources, "skip-owned-resources", nil, "Resources whose owned objects are skipped without --skip-owned, as <resource> or <resource>.<group>, glob patterns are allowed (i.e. pods,endpointslices.discovery.k8s.io)")
+	cmd.Flags().StringSliceVar(&opt.manager.Owned.KeepResources, "keep-owned-resources", nil, "Resources whose owned objects are kept with --skip-owned, as <resource> or <resource>.<group>, glob patterns are allowed (i.e. persistentvolumeclaims)")
+	cmd.Flags().StringVarP(&opt.manager.LabelSelector, "selector", "l", "", "Label selector to backup only the matching objects (i.e. app.kubernetes.io/part-of=payments)")
+	cmd.Flags().StringVar(&opt.manager.FieldSelector, "field-selector", "", "Field selector to backup only the matching objects, resources that do not support it are skipped")
+	cmd.Flags().StringVar(&opt.manager.SecretsMode, "secrets-mode", opt.manager.SecretsMode, "How to store Secrets: skip (not dumped at all), redact (keep keys, blank values) or encrypt (encrypt values with --secrets-public-key). The last applied configuration of kubectl is removed or encrypted too.")
+	cmd.Flags().Int64Var(&opt.manager.ChunkSize, "chunk-size", opt.manager.ChunkSize, "Maximum number of objects fetched by a single list call (0 lists all objects of a resource at once)")
+	cmd.Flags().StringVar(&opt.manager.Layout, "layout", opt.manager.Layout, "Directory layout of dumped objects: hierarchical (namespaces/<ns>/<group>/<resource>/<name>.yaml) or flat (<group>_<kind>/<ns>_<name>.yaml)")
+	cmd.Flags().BoolVar(&opt.manager.BestEffort, "best-effort", false, "Skip the group/versions and resources that fail to be discovered or listed instead of failing the backup (exits with code 2 if any was skipped)")
+	cmd.Flags().IntVar(&opt.manager.Concurrency, "concurrency", opt.manager.Concurrency, "Number of resources listed in parallel")
+	cmd.Flags().StringVar(&opt.publicKey, "secrets-public-key", "", "Base64 encoded X25519 public key used to encrypt Secret values (i.e. openssl pkey -in key.pem -pubout -outform DER | tail -c 32 | base64). Values are sealed with X25519 and AES-256-GCM in a format of cluster-tool, not with age.")
 	cmd.Flags().StringVar(&opt.backupDir, "backup-dir", opt.backupDir, "Directory where dumped YAML files will be stored temporarily")
 
-	cmd.Flags().BoolVar(&opt.backup.EnableCache, "cache", opt.backup.EnableCache, "Specify weather to enable caching for restic")
-	cmd.Flags().StringVar(&opt.backup.Hostname, "hostname", "", "Name of the host machine")
-
-	cmd.Flags().StringVar(&opt.backup.Provider, "provider", "", "Backend provider (i.e. gcs, s3, azure etc)")
-	cmd.Flags().StringVar(&opt.backup.SecretDir, "secret-dir", "", "Directory where storage secret has been mounted")
-	cmd.Flags().StringVar(&opt.backup.Bucket, "bucket", "", "Name of the cloud bucket/container (keep empty for local backend)")
-	cmd.Flags().StringVar(&opt.backup.Endpoint, "endpoint", "", "Endpoint for s3/s3 compatible backend")
-	cmd.Flags().StringVar(&opt.backup.Path, "path", "", "Directory inside the bucket where backup will be stored")
+	addResticFlags(cmd.Flags(), &opt.backup)
 	cmd.Flags().StringVar(&opt.backup.OutputDir, "output-dir", "", "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
 
-	cmd.Flags().StringVar(&opt.backup.RetentionPolicy.Policy, "retention-policy.policy", "", "Specify a retention policy")
-	cmd.Flags().StringVar(&opt.backup.RetentionPolicy.Value, "retention-policy.value", "", "Value for specified retention policy")
+	addRetentionFlags(cmd.Flags(), &opt.backup.RetentionPolicy)
 	cmd.Flags().BoolVar(&opt.backup.RetentionPolicy.Prune, "retention-policy.prune", false, "Specify weather to prune old snapshot data")
 	cmd.Flags().BoolVar(&opt.backup.RetentionPolicy.DryRun, "retention-policy.dryrun", false, "Specify weather to test retention policy without deleting actual data")
 
@@ -90,8 +172,20 @@ func NewCmdBackup() *cobra.Command {
 	return cmd
 }
 
-func runBackup(backupOpt *restic.BackupOptions, masterUrl, kubeconfigPath, context, backupDir string, sanitize bool) (*restic.BackupOutput, error) {
-	config, err := clientcmd.BuildConfigFromFlags(masterUrl, kubeconfigPath)
+// backupLong documents the resources skipped unless --include-ephemeral is set
+func backupLong() string {
+	var b strings.Builder
+	b.WriteString("Takes a backup YAMLs of Kubernetes api objects.\n\n")
+	b.WriteString("These resources are skipped unless --include-ephemeral is set or --include-resources names them (glob patterns do not count):\n")
+	for _, e := range backup.EphemeralResources {
+		fmt.Fprintf(&b, "  %-34s %s\n", e.String(), e.Reason)
+	}
+	fmt.Fprintf(&b, "  %-34s %s\n", "secrets in "+backup.EphemeralSecretsNamespace, backup.EphemeralSecretsReason)
+	return b.String()
+}
+
+func runBackup(backupOpt *restic.BackupOptions, masterUrl, kubeconfigPath, context, backupDir string, mgrOpt backup.Options) (*restic.BackupOutput, error) {
+	config, err := buildConfig(masterUrl, kubeconfigPath, context)
 	if err != nil {
 		return nil, err
 	}
@@ -100,16 +194,21 @@ func runBackup(backupOpt *restic.BackupOptions, masterUrl, kubeconfigPath, conte
 		cfg, err := clientcmd.LoadFromFile(kubeconfigPath)
 		if err == nil {
 			context = cfg.CurrentContext
-		}else{
+		} else {
 			// using incluster config. so no context. use default.
 			context = "default"
 		}
 	}
-	mgr := backup.NewBackupManager(context, config, sanitize)
+	clusterName := backupOpt.ClusterName
+	if clusterName == "" {
+		clusterName = context
+	}
+	mgr := backup.NewBackupManager(clusterName, config, mgrOpt)
 
-	_, err = mgr.BackupToDir(backupDir)
-	if err != nil {
-		return nil, err
+	_, dumpErr := mgr.BackupToDir(backupDir)
+	partialErr, partial := dumpErr.(*backup.PartialBackupError)
+	if dumpErr != nil && !partial {
+		return nil, dumpErr
 	}
 
 	// Setup Environment variables for restic cli
@@ -125,8 +224,14 @@ func runBackup(backupOpt *restic.BackupOptions, masterUrl, kubeconfigPath, conte
 		return nil, err
 	}
 
+	// Tag partial backups, so that they can be told apart in the snapshot list
+	tags := []string{backup.ClusterTag(clusterName)}
+	if mgr.Metadata().Partial || partial {
+		tags = append(tags, backup.TagPartial)
+	}
+
 	// Backup the dumped YAMLs stored temporarily in opt.backupDir
-	out, err := w.Backup(backupDir, nil)
+	out, err := w.Backup(backupDir, tags)
 	if err != nil {
 		return nil, err
 	}
@@ -147,13 +252,14 @@ func runBackup(backupOpt *restic.BackupOptions, masterUrl, kubeconfigPath, conte
 	// Extract information from output of "check" command
 	backupOutput.ExtractCheckInfo(out)
 
-	// Cleanup old snapshot according to retention policy
-	out, err = w.Cleanup(backupOpt.RetentionPolicy.Policy, backupOpt.RetentionPolicy.Value, backupOpt.RetentionPolicy.Prune, backupOpt.RetentionPolicy.DryRun)
+	// Cleanup old snapshot of this cluster according to retention policy
+	backupOpt.RetentionPolicy.Tags = append(backupOpt.RetentionPolicy.Tags, backup.ClusterTag(clusterName))
+	out, err = w.Cleanup(backupOpt.RetentionPolicy)
 	if err != nil {
 		return nil, err
 	}
 	// Extract information from output of cleanup command
-	err = backupOutput.ExtractCleanupInfo(out)
+	err = backupOutput.ExtractCleanupInfo(out, backupOpt.RetentionPolicy)
 	if err != nil {
 		return nil, err
 	}
@@ -168,5 +274,68 @@ func runBackup(backupOpt *restic.BackupOptions, masterUrl, kubeconfigPath, conte
 	if err != nil {
 		return nil, err
 	}
+
+	if partial {
+		for _, f := range partialErr.Failures {
+			backupOutput.BackupStats.FailedResources = append(backupOutput.BackupStats.FailedResources, restic.FailedResource{
+				GroupVersion: f.GroupVersion,
+				Resource:     f.Resource,
+				Namespace:    f.Namespace,
+				Error:        f.Error,
+			})
+		}
+		return backupOutput, partialErr
+	}
 	return backupOutput, nil
 }
+
+func addKubeFlags(fs *pflag.FlagSet, masterUrl, kubeconfigPath, context *string) {
+	fs.StringVar(masterUrl, "master-url", "", "URL of master node")
+	fs.StringVar(kubeconfigPath, "kubeconfig", *kubeconfigPath, "kubeconfig file pointing at the 'core' kubernetes server")
+	fs.StringVar(context, "context", "", "Context to use from kubeconfig file")
+}
+
+// buildConfig returns the config of context in the kubeconfig file, or of its
+// current context if context is empty. Without any of the flags set, the
+// in-cluster config is used. A context without a kubeconfig file is looked up
+// in $KUBECONFIG or ~/.kube/config, like kubectl does.
+func buildConfig(masterUrl, kubeconfigPath, context string) (*rest.Config, error) {
+	if context == "" {
+		return clientcmd.BuildConfigFromFlags(masterUrl, kubeconfigPath)
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfigPath
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{
+			ClusterInfo:    clientcmdapi.Cluster{Server: masterUrl},
+			CurrentContext: context,
+		}).ClientConfig()
+}
+
+func addResticFlags(fs *pflag.FlagSet, opt *restic.BackupOptions) {
+	fs.BoolVar(&opt.EnableCache, "cache", opt.EnableCache, "Specify weather to enable caching for restic")
+	fs.StringVar(&opt.Hostname, "hostname", "", "Name of the host machine")
+	fs.StringVar(&opt.ClusterName, "cluster-name", "", "Name of the cluster, unique among the clusters sharing the repository. backup tags its snapshots with cluster:<name>, the name defaults to the kubeconfig context there (\"default\" in a pod), and its retention policy only removes snapshots with this tag. The other commands only consider the snapshots with this tag if it is set.")
+
+	fs.StringVar(&opt.Provider, "provider", "", "Backend provider (i.e. gcs, s3, azure etc)")
+	fs.StringVar(&opt.SecretDir, "secret-dir", "", "Directory where storage secret has been mounted")
+	fs.StringVar(&opt.Bucket, "bucket", "", "Name of the cloud bucket/container (keep empty for local backend)")
+	fs.StringVar(&opt.Endpoint, "endpoint", "", "Endpoint for s3/s3 compatible backend")
+	fs.StringVar(&opt.Path, "path", "", "Directory inside the bucket where backup will be stored")
+}
+
+// addRetentionFlags adds the keep rules of a retention policy. The rules are combined,
+// a snapshot is kept if any of them matches.
+func addRetentionFlags(fs *pflag.FlagSet, policy *restic.RetentionPolicy) {
+	fs.StringVar(&policy.Policy, "retention-policy.policy", "", "Specify a retention policy")
+	fs.StringVar(&policy.Value, "retention-policy.value", "", "Value for specified retention policy")
+	fs.IntVar(&policy.KeepLast, "retention-policy.keep-last", 0, "Keep the last n snapshots")
+	fs.IntVar(&policy.KeepHourly, "retention-policy.keep-hourly", 0, "Keep the last snapshot of the last n hours that have snapshots")
+	fs.IntVar(&policy.KeepDaily, "retention-policy.keep-daily", 0, "Keep the last snapshot of the last n days that have snapshots")
+	fs.IntVar(&policy.KeepWeekly, "retention-policy.keep-weekly", 0, "Keep the last snapshot of the last n weeks that have snapshots")
+	fs.IntVar(&policy.KeepMonthly, "retention-policy.keep-monthly", 0, "Keep the last snapshot of the last n months that have snapshots")
+	fs.IntVar(&policy.KeepYearly, "retention-policy.keep-yearly", 0, "Keep the last snapshot of the last n years that have snapshots")
+	fs.StringVar(&policy.KeepWithin, "retention-policy.keep-within", "", "Keep the snapshots taken within this duration before the latest snapshot (i.e. 30d, 1y6m)")
+	fs.StringSliceVar(&policy.KeepTags, "retention-policy.keep-tag", nil, "Keep the snapshots having any of these tags")
+	fs.StringVar(&policy.GroupBy, "retention-policy.group-by", "tags,paths", "Apply the rules to each group of snapshots with the same host, tags and/or paths (only the snapshots of --hostname are considered if it is set). Snapshots are tagged with their cluster, the host is the name of the pod taking the backup.")
+}
@@ -0,0 +1,219 @@
+package cmds
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"os"
+	"sort"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+type cloneOptions struct {
+	masterUrl            string
+	kubeconfigPath       string
+	context              string
+	sourceMasterUrl      string
+	sourceKubeconfigPath string
+	sourceContext        string
+	namespaces           []string
+	namespaceMapping     map[string]string
+	clusterDomain        string
+	snapshot             string
+	privateKeyFile       string
+	copySecrets          bool
+	concurrency          int
+	dryRun               bool
+	backup               restic.BackupOptions
+}
+
+func NewCmdClone() *cobra.Command {
+
+	opt := cloneOptions{
+		snapshot:      "latest",
+		clusterDomain: "cluster.local",
+		concurrency:   1,
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "clone",
+		Short:             "Clones namespaces from a backup snapshot or a live cluster into a cluster",
+		Long:              "Creates the objects of one or more namespaces in the target cluster. The objects are read from a backup snapshot, or from a live cluster with --source-kubeconfig. With --namespace-mapping old=new the objects are created in another namespace and the namespace references in RoleBindings and in the Service DNS names of env values are rewritten. Objects whose controller is cloned too are skipped, their controller creates them again. The owner references of the other objects are removed, as their owners get new uids. Objects are sanitized with the portable profile.",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !opt.liveSource() {
+				flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			}
+			if len(opt.namespaces) == 0 {
+				for ns := range opt.namespaceMapping {
+					opt.namespaces = append(opt.namespaces, ns)
+				}
+				sort.Strings(opt.namespaces)
+			}
+			if len(opt.namespaces) == 0 {
+				return errors.New("no namespace to clone, set --namespaces or --namespace-mapping")
+			}
+
+			objects, privateKey, err := readCloneObjects(&opt)
+			if err != nil {
+				return err
+			}
+			mapping := restore.NewNamespaceMapping(opt.namespaceMapping, opt.clusterDomain, objects)
+			for _, obj := range objects {
+				if err := mapping.Apply(obj); err != nil {
+					return err
+				}
+			}
+			if opt.dryRun {
+				return printObjects(os.Stdout, objects)
+			}
+
+			config, err := buildConfig(opt.masterUrl, opt.kubeconfigPath, opt.context)
+			if err != nil {
+				return err
+			}
+			err = restore.NewRestoreManager(config, restore.Options{SecretsPrivateKey: privateKey}).Restore(objects)
+			if err != nil {
+				return err
+			}
+			log.Infof("Cloned %d objects", len(objects))
+			return nil
+		},
+	}
+	addKubeFlags(cmd.Flags(), &opt.masterUrl, &opt.kubeconfigPath, &opt.context)
+	cmd.Flags().StringVar(&opt.sourceMasterUrl, "source-master-url", "", "URL of master node of the cluster to clone from")
+	cmd.Flags().StringVar(&opt.sourceKubeconfigPath, "source-kubeconfig", "", "kubeconfig file of the cluster to clone from, instead of a backup snapshot")
+	cmd.Flags().StringVar(&opt.sourceContext, "source-context", "", "Name of the kubeconfig context of the cluster to clone from, instead of a backup snapshot")
+	cmd.Flags().StringSliceVar(&opt.namespaces, "namespaces", nil, "Namespaces to clone (defaults to the namespaces of --namespace-mapping)")
+	cmd.Flags().StringToStringVar(&opt.namespaceMapping, "namespace-mapping", nil, "Namespaces to create the cloned objects in as old=new (i.e. prod=staging)")
+	cmd.Flags().StringVar(&opt.clusterDomain, "cluster-domain", opt.clusterDomain, "DNS domain of the cluster, the namespace of Service DNS names ending in .svc.<cluster-domain> is rewritten")
+	cmd.Flags().StringVar(&opt.snapshot, "snapshot", opt.snapshot, "ID of the snapshot to clone from (use 'latest' for the most recent snapshot or 'latest~n' for the n-th snapshot before it)")
+	cmd.Flags().StringVar(&opt.privateKeyFile, "secrets-private-key-file", "", "File holding the X25519 private key to decrypt Secrets backed up with --secrets-mode=encrypt")
+	cmd.Flags().BoolVar(&opt.copySecrets, "copy-secrets", false, "Also clone the Secrets when cloning from a live cluster")
+	cmd.Flags().IntVar(&opt.concurrency, "concurrency", opt.concurrency, "Number of resources listed in parallel when cloning from a live cluster")
+	cmd.Flags().BoolVar(&opt.dryRun, "dry-run", false, "Print the cloned objects instead of creating them")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+func (opt *cloneOptions) liveSource() bool {
+	return opt.sourceMasterUrl != "" || opt.sourceKubeconfigPath != "" || opt.sourceContext != ""
+}
+
+// readCloneObjects reads the sanitized objects of the cloned namespaces. It also
+// returns the key to decrypt the Secrets among them.
+func readCloneObjects(opt *cloneOptions) ([]*unstructured.Unstructured, *ecdh.PrivateKey, error) {
+	var objects []*unstructured.Unstructured
+	var privateKey *ecdh.PrivateKey
+	var err error
+
+	if opt.liveSource() {
+		config, err := buildConfig(opt.sourceMasterUrl, opt.sourceKubeconfigPath, opt.sourceContext)
+		if err != nil {
+			return nil, nil, err
+		}
+		mgrOpt := backup.Options{
+			Sanitize:      true,
+			SanitizeRules: &backup.SanitizeRules{Profile: backup.ProfilePortable},
+			Filter:        backup.ResourceFilter{IncludeNamespaces: opt.namespaces},
+			SecretsMode:   backup.SecretsSkip,
+			ChunkSize:     500,
+			Concurrency:   opt.concurrency,
+		}
+		if opt.copySecrets {
+			// Secret values never leave the process in plain text, they are
+			// encrypted for a throwaway key and decrypted when created.
+			privateKey, err = ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, nil, err
+			}
+			mgrOpt.SecretsMode = backup.SecretsEncrypt
+			mgrOpt.SecretsPublicKey = privateKey.PublicKey()
+		}
+		log.Infof("Reading namespaces %v from %s", opt.namespaces, config.Host)
+		objects, err = backup.NewBackupManager("", config, mgrOpt).BackupToObjects()
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if opt.privateKeyFile != "" {
+			privateKey, err = backup.ReadPrivateKey(opt.privateKeyFile)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		w, err := newResticWrapper(&opt.backup)
+		if err != nil {
+			return nil, nil, err
+		}
+		snapshots, err := resolveSnapshots(w, &opt.backup, opt.snapshot)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Infof("Reading namespaces %v from snapshot %s", opt.namespaces, snapshots[0].ShortID())
+		_, objects, err = loadSnapshotObjects(w, opt.backup.ScratchDir, snapshots[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		sanitizer, err := backup.NewSanitizer(&backup.SanitizeRules{Profile: backup.ProfilePortable})
+		if err != nil {
+			return nil, nil, err
+		}
+		kept := objects[:0]
+		for _, obj := range objects {
+			ok, err := sanitizer.Sanitize(obj.Object)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				kept = append(kept, obj)
+			}
+		}
+		objects = kept
+	}
+
+	namespaces := sets.NewString(opt.namespaces...)
+	var result []*unstructured.Unstructured
+	for _, obj := range objects {
+		if !namespaces.Has(obj.GetNamespace()) &&
+			!(obj.GetAPIVersion() == "v1" && obj.GetKind() == "Namespace" && namespaces.Has(obj.GetName())) {
+			continue
+		}
+		result = append(result, obj)
+	}
+	result, skipped := backup.SkipOwned(result, backup.OwnedPolicy{Skip: true})
+	for _, s := range skipped {
+		log.Infof("Skipping %s %s/%s, %s %s creates it again", s.Kind, s.Namespace, s.Name, s.Owner.Kind, s.Owner.Name)
+	}
+	for _, obj := range result {
+		// the owners get new uids, the garbage collector would remove objects referring to the old ones
+		obj.SetOwnerReferences(nil)
+	}
+	for _, ns := range opt.namespaces {
+		found := false
+		for _, obj := range result {
+			if obj.GetNamespace() == ns {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Warningf("Namespace %s has no objects to clone", ns)
+		}
+	}
+	return result, privateKey, nil
+}
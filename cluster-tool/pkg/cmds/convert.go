@@ -0,0 +1,125 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/convert"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type convertOptions struct {
+	kubeVersion string
+	dir         string
+	snapshot    string
+	outputDir   string
+	output      string
+	backup      restic.BackupOptions
+}
+
+func NewCmdConvert() *cobra.Command {
+
+	opt := convertOptions{
+		snapshot: "latest",
+		output:   OutputTable,
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "convert",
+		Short:             "Converts the objects of a snapshot to the api versions served by a Kubernetes version",
+		Long:              "Rewrites the objects of a restored snapshot directory (--dir), or of a snapshot restored to --output-dir, whose api versions are no longer served by the target Kubernetes version. Objects that cannot be converted automatically are reported and left untouched, the command then fails. The conversions are reimplemented after the upstream conversion functions, which cannot be vendored with the Kubernetes 1.13 client libraries of this tool, review the converted objects before restoring them.",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "kube-version")
+			if opt.dir == "" {
+				flags.EnsureRequiredFlags(cmd, "output-dir", "provider", "path", "secret-dir")
+			}
+			if err := validateOutputFormat(opt.output); err != nil {
+				return err
+			}
+			minor, err := convert.ParseKubeVersion(opt.kubeVersion)
+			if err != nil {
+				return err
+			}
+
+			report, err := runConvert(&opt, minor)
+			if err != nil {
+				return err
+			}
+			if err := printConvertReport(os.Stdout, opt.output, report); err != nil {
+				return err
+			}
+			if len(report.Failed) > 0 {
+				cmd.SilenceUsage = true
+				return errors.Errorf("%d objects could not be converted", len(report.Failed))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opt.kubeVersion, "kube-version", "", "Kubernetes version to convert the objects for (i.e. 1.22)")
+	cmd.Flags().StringVar(&opt.dir, "dir", "", "Directory holding a restored snapshot, converted in place")
+	cmd.Flags().StringVar(&opt.snapshot, "snapshot", opt.snapshot, "ID of the snapshot to convert, if --dir is not set (use 'latest' for the most recent snapshot or 'latest~n' for the n-th snapshot before it)")
+	cmd.Flags().StringVar(&opt.outputDir, "output-dir", "", "Directory to restore the converted snapshot to, if --dir is not set")
+	cmd.Flags().StringVarP(&opt.output, "output", "o", opt.output, "Output format: table, json or yaml")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+func runConvert(opt *convertOptions, minor int) (*convert.Report, error) {
+	dir := opt.dir
+	if dir == "" {
+		w, err := newResticWrapper(&opt.backup)
+		if err != nil {
+			return nil, err
+		}
+		snapshots, err := resolveSnapshots(w, &opt.backup, opt.snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(opt.outputDir, 0755); err != nil {
+			return nil, err
+		}
+		if _, err := w.RestoreSnapshot(snapshots[0].ID, opt.outputDir); err != nil {
+			return nil, err
+		}
+		dir = opt.outputDir
+	}
+
+	snapshotDir, err := restore.FindSnapshotDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find snapshot in %s", dir)
+	}
+	log.Infof("Converting objects in %s for Kubernetes %s", snapshotDir, opt.kubeVersion)
+	return convert.Dir(snapshotDir, minor)
+}
+
+func printConvertReport(out io.Writer, format string, report *convert.Report) error {
+	return printOutput(out, format, report, func(w io.Writer) {
+		fmt.Fprintf(w, "Converted %d objects for Kubernetes %s\n", len(report.Converted), report.KubeVersion)
+		if len(report.Converted) > 0 {
+			fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tFROM\tTO")
+			for _, r := range report.Converted {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Kind, r.Namespace, r.Name, r.APIVersion, r.To)
+			}
+		}
+		if len(report.Failed) > 0 {
+			fmt.Fprintf(w, "\n%d objects could not be converted\n", len(report.Failed))
+			fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tAPIVERSION\tREASON")
+			for _, r := range report.Failed {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Kind, r.Namespace, r.Name, r.APIVersion, r.Error)
+			}
+		}
+	})
+}
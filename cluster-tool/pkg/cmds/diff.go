@@ -0,0 +1,121 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/diff"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+type diffOptions struct {
+	output      string
+	showSecrets bool
+	backup      restic.BackupOptions
+}
+
+func NewCmdDiff() *cobra.Command {
+
+	opt := diffOptions{
+		output: OutputTable,
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "diff <old-snapshot> <new-snapshot>",
+		Short:             "Shows the objects added, removed and modified between two snapshots",
+		Long:              "Shows the objects added, removed and modified between two snapshots. Snapshots are given by ID, 'latest' or 'latest~n' for the n-th snapshot before the latest one.",
+		Args:              cobra.ExactArgs(2),
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			if err := validateOutputFormat(opt.output); err != nil {
+				return err
+			}
+
+			diffs, err := runDiff(&opt, args[0], args[1])
+			if err != nil {
+				return err
+			}
+			return printObjectDiffs(os.Stdout, opt.output, diffs)
+		},
+	}
+	cmd.Flags().StringVarP(&opt.output, "output", "o", opt.output, "Output format: table, json or yaml")
+	cmd.Flags().BoolVar(&opt.showSecrets, "show-secrets", false, "Show the values of Secrets that differ instead of their hash")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+func runDiff(opt *diffOptions, oldRef, newRef string) ([]diff.ObjectDiff, error) {
+	w, err := newResticWrapper(&opt.backup)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := resolveSnapshots(w, &opt.backup, oldRef, newRef)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Comparing snapshot %s with %s", snapshots[0].ShortID(), snapshots[1].ShortID())
+
+	_, oldObjects, err := loadSnapshotObjects(w, opt.backup.ScratchDir, snapshots[0])
+	if err != nil {
+		return nil, err
+	}
+	_, newObjects, err := loadSnapshotObjects(w, opt.backup.ScratchDir, snapshots[1])
+	if err != nil {
+		return nil, err
+	}
+	return diff.Objects(oldObjects, newObjects, opt.showSecrets)
+}
+
+func printObjectDiffs(out io.Writer, format string, diffs []diff.ObjectDiff) error {
+	if diffs == nil {
+		diffs = make([]diff.ObjectDiff, 0)
+	}
+	return printOutput(out, format, diffs, func(w io.Writer) {
+		writeObjectDiffs(w, diffs)
+	})
+}
+
+func writeObjectDiffs(w io.Writer, diffs []diff.ObjectDiff) {
+	counts := make(map[string]int)
+	for _, d := range diffs {
+		counts[d.Change]++
+		fmt.Fprintf(w, "%-8s  %s\n", d.Change, d.ObjectKey)
+		printFieldChanges(w, d.Fields)
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d modified\n", counts[diff.Added], counts[diff.Removed], counts[diff.Modified])
+}
+
+// printFieldChanges prints the old and new values of each field as YAML
+func printFieldChanges(w io.Writer, fields []diff.FieldChange) {
+	for _, f := range fields {
+		fmt.Fprintf(w, "    %s:\n", f.Path)
+		printValue(w, "-", f.Old)
+		printValue(w, "+", f.New)
+	}
+}
+
+func printValue(w io.Writer, prefix string, v interface{}) {
+	if v == nil {
+		return
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		data = []byte(fmt.Sprint(v))
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		fmt.Fprintf(w, "    %s   %s\n", prefix, line)
+	}
+}
@@ -0,0 +1,136 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/diff"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/spf13/cobra"
+)
+
+type driftOptions struct {
+	masterUrl      string
+	kubeconfigPath string
+	context        string
+	snapshot       string
+	concurrency    int
+	output         string
+	showSecrets    bool
+	backup         restic.BackupOptions
+}
+
+// driftReport is printed by the drift command
+type driftReport struct {
+	// Snapshot is the ID of the snapshot the live objects are compared with
+	Snapshot     string    `json:"snapshot"`
+	SnapshotTime time.Time `json:"snapshotTime"`
+	// Drifted is true if any object has been added, removed or modified since the snapshot
+	Drifted bool              `json:"drifted"`
+	Objects []diff.ObjectDiff `json:"objects"`
+}
+
+func NewCmdDrift() *cobra.Command {
+
+	opt := driftOptions{
+		snapshot:    "latest",
+		concurrency: 1,
+		output:      OutputTable,
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "drift",
+		Short:             "Compares the live objects of the cluster with a backup snapshot",
+		Long:              "Compares the live objects of the cluster with a backup snapshot. Objects are listed with the filters, selectors and sanitization the snapshot was taken with. Exits with code 1 if any object has been added, removed or modified since the snapshot.",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			if err := validateOutputFormat(opt.output); err != nil {
+				return err
+			}
+
+			report, err := runDrift(&opt)
+			if err != nil {
+				return err
+			}
+			if err := printDriftReport(os.Stdout, opt.output, report); err != nil {
+				return err
+			}
+			if report.Drifted {
+				cmd.SilenceUsage = true
+				return &diff.DriftError{Diffs: len(report.Objects)}
+			}
+			return nil
+		},
+	}
+	addKubeFlags(cmd.Flags(), &opt.masterUrl, &opt.kubeconfigPath, &opt.context)
+	cmd.Flags().StringVar(&opt.snapshot, "snapshot", opt.snapshot, "ID of the snapshot to compare with (use 'latest' for the most recent snapshot or 'latest~n' for the n-th snapshot before it)")
+	cmd.Flags().IntVar(&opt.concurrency, "concurrency", opt.concurrency, "Number of resources listed in parallel")
+	cmd.Flags().StringVarP(&opt.output, "output", "o", opt.output, "Output format: table, json or yaml")
+	cmd.Flags().BoolVar(&opt.showSecrets, "show-secrets", false, "Show the values of Secrets that differ instead of their hash")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+func runDrift(opt *driftOptions) (*driftReport, error) {
+	config, err := buildConfig(opt.masterUrl, opt.kubeconfigPath, opt.context)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := newResticWrapper(&opt.backup)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := resolveSnapshots(w, &opt.backup, opt.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := snapshots[0]
+	md, snapshotObjects, err := loadSnapshotObjects(w, opt.backup.ScratchDir, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	// list the live objects the same way the snapshot was taken
+	mgrOpt := md.Options()
+	mgrOpt.ChunkSize = 500
+	mgrOpt.Concurrency = opt.concurrency
+	log.Infof("Comparing live objects with snapshot %s", snapshot.ShortID())
+	liveObjects, err := backup.NewBackupManager(md.Cluster, config, mgrOpt).BackupToObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	diffs, err := diff.Objects(snapshotObjects, liveObjects, opt.showSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if diffs == nil {
+		diffs = make([]diff.ObjectDiff, 0)
+	}
+	return &driftReport{
+		Snapshot:     snapshot.ID,
+		SnapshotTime: snapshot.Time,
+		Drifted:      len(diffs) > 0,
+		Objects:      diffs,
+	}, nil
+}
+
+func printDriftReport(out io.Writer, format string, report *driftReport) error {
+	return printOutput(out, format, report, func(w io.Writer) {
+		fmt.Fprintf(w, "Snapshot %s taken at %s\n", restic.ShortID(report.Snapshot), report.SnapshotTime.Local().Format(time.RFC3339))
+		writeObjectDiffs(w, report.Objects)
+	})
+}
@@ -0,0 +1,122 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type forgetOptions struct {
+	output string
+	backup restic.BackupOptions
+}
+
+func NewCmdForget() *cobra.Command {
+
+	opt := forgetOptions{
+		output: OutputTable,
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "forget [snapshot-id...]",
+		Short:             "Removes the given snapshots, or the snapshots not kept by a retention policy",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			if err := validateOutputFormat(opt.output); err != nil {
+				return err
+			}
+			policy := opt.backup.RetentionPolicy
+			if len(args) > 0 && !policy.Empty() {
+				return errors.New("snapshot IDs can not be combined with a retention policy")
+			}
+			if len(args) == 0 {
+				if err := policy.Validate(); err != nil {
+					return errors.Wrap(err, "either snapshot IDs or a retention policy must be specified")
+				}
+			}
+
+			if opt.backup.ClusterName != "" {
+				if len(args) > 0 {
+					return errors.New("snapshot IDs can not be combined with --cluster-name")
+				}
+				opt.backup.RetentionPolicy.Tags = append(opt.backup.RetentionPolicy.Tags, backup.ClusterTag(opt.backup.ClusterName))
+			}
+
+			plan, err := runForget(&opt.backup, args)
+			if err != nil {
+				return err
+			}
+			if plan.DryRun {
+				log.Infoln("Dry run, no snapshot has been removed")
+			}
+			return printRetentionPlan(os.Stdout, opt.output, plan)
+		},
+	}
+	addRetentionFlags(cmd.Flags(), &opt.backup.RetentionPolicy)
+	cmd.Flags().BoolVar(&opt.backup.RetentionPolicy.Prune, "prune", false, "Specify weather to prune the data of removed snapshots")
+	cmd.Flags().BoolVar(&opt.backup.RetentionPolicy.DryRun, "dry-run", false, "Only print which snapshots would be kept or removed")
+	cmd.Flags().StringVarP(&opt.output, "output", "o", opt.output, "Output format: table, json or yaml")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+func runForget(opt *restic.BackupOptions, snapshotIDs []string) (*restic.RetentionPlan, error) {
+	w, err := newResticWrapper(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := opt.RetentionPolicy
+	if len(snapshotIDs) == 0 {
+		out, err := w.Cleanup(policy)
+		if err != nil {
+			return nil, err
+		}
+		return restic.ExtractRetentionPlan(out, policy)
+	}
+
+	// Read the removed snapshots first, they can not be listed afterwards
+	snapshots, err := w.ListSnapshots(snapshotIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) != len(snapshotIDs) {
+		return nil, errors.Errorf("found %d of %d snapshots %s", len(snapshots), len(snapshotIDs), strings.Join(snapshotIDs, ", "))
+	}
+	if !policy.DryRun {
+		if _, err := w.DeleteSnapshots(snapshotIDs, policy.Prune); err != nil {
+			return nil, err
+		}
+	}
+	return restic.DeletionPlan(snapshots, policy.DryRun), nil
+}
+
+func printRetentionPlan(out io.Writer, format string, plan *restic.RetentionPlan) error {
+	return printOutput(out, format, plan, func(w io.Writer) {
+		fmt.Fprintln(w, "ACTION\tID\tTIME\tHOST\tTAGS\tREASONS")
+		printDecisions(w, "keep", plan.Keep)
+		printDecisions(w, "remove", plan.Remove)
+	})
+}
+
+func printDecisions(w io.Writer, action string, decisions []restic.SnapshotDecision) {
+	for _, d := range decisions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", action, d.ShortID(), d.Time.Local().Format(time.RFC3339), d.Hostname, strings.Join(d.Tags, ","), strings.Join(d.Reasons, ", "))
+	}
+}
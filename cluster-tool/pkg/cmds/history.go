@@ -0,0 +1,166 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/diff"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type historyOptions struct {
+	namespace   string
+	showDiff    bool
+	showSecrets bool
+	since       string
+	until       string
+	output      string
+	backup      restic.BackupOptions
+}
+
+// historyEntry is a snapshot in which an object was added, removed or modified
+type historyEntry struct {
+	Snapshot string    `json:"snapshot"`
+	Time     time.Time `json:"time"`
+	// Change is one of added, removed or modified
+	Change string `json:"change"`
+	// Object is the version of the object in the snapshot, unset if it was removed
+	Object map[string]interface{} `json:"object,omitempty"`
+	// Fields lists the fields changed since the previous version, if requested
+	Fields []diff.FieldChange `json:"fields,omitempty"`
+}
+
+func NewCmdHistory() *cobra.Command {
+
+	opt := historyOptions{
+		namespace: "default",
+		output:    OutputTable,
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "history <resource>/<name>",
+		Short:             "Shows the versions of an object in the snapshots in which it was added, removed or modified",
+		Long:              "Shows the versions of an object in the snapshots in which it was added, removed or modified. The resource is given by its plural, singular or short name or its kind, optionally followed by its group (i.e. deployments.apps/api). Snapshots whose filters, selectors or failures left out the object are skipped instead of reporting it removed.",
+		Args:              cobra.ExactArgs(1),
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			if err := validateOutputFormat(opt.output); err != nil {
+				return err
+			}
+			ref, err := parseObjectRef(args[0], opt.namespace)
+			if err != nil {
+				return err
+			}
+
+			history, err := runHistory(&opt, ref)
+			if err != nil {
+				return err
+			}
+			return printHistory(os.Stdout, opt.output, history)
+		},
+	}
+	cmd.Flags().StringVarP(&opt.namespace, "namespace", "n", opt.namespace, "Namespace of the object")
+	cmd.Flags().BoolVar(&opt.showDiff, "diff", false, "Show the fields changed between adjacent versions")
+	cmd.Flags().BoolVar(&opt.showSecrets, "show-secrets", false, "Show the values of Secrets instead of their hash")
+	cmd.Flags().StringVar(&opt.since, "since", "", "Only walk the snapshots taken since this time, as an RFC3339 timestamp, a date or a duration (i.e. 72h)")
+	cmd.Flags().StringVar(&opt.until, "until", "", "Only walk the snapshots taken until this time, as an RFC3339 timestamp, a date or a duration (i.e. 24h)")
+	cmd.Flags().StringVarP(&opt.output, "output", "o", opt.output, "Output format: table, json or yaml")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+func runHistory(opt *historyOptions, ref objectRef) ([]historyEntry, error) {
+	filter := snapshotFilter(&opt.backup)
+	var err error
+	if filter.Since, err = parseTime(opt.since); err != nil {
+		return nil, err
+	}
+	if filter.Until, err = parseTime(opt.until); err != nil {
+		return nil, err
+	}
+
+	w, err := newResticWrapper(&opt.backup)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := listSnapshots(w, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]historyEntry, 0)
+	var prev *unstructured.Unstructured
+	for _, snapshot := range snapshots {
+		log.Infof("Reading %s from snapshot %s", ref, snapshot.ShortID())
+		reader, err := newSnapshotReader(w, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := reader.find(ref)
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			if obj, err = diff.Normalize(obj, opt.showSecrets); err != nil {
+				return nil, err
+			}
+		}
+
+		entry := historyEntry{
+			Snapshot: snapshot.ID,
+			Time:     snapshot.Time,
+		}
+		switch {
+		case prev == nil && obj == nil:
+			continue
+		case prev == nil:
+			entry.Change = diff.Added
+		case obj == nil:
+			if !reader.covers(prev) {
+				log.Infof("Skipping snapshot %s, it could not hold %s", snapshot.ShortID(), ref)
+				continue
+			}
+			entry.Change = diff.Removed
+		default:
+			fields := diff.Fields(prev.Object, obj.Object)
+			if len(fields) == 0 {
+				continue
+			}
+			entry.Change = diff.Modified
+			if opt.showDiff {
+				entry.Fields = fields
+			}
+		}
+		if obj != nil {
+			entry.Object = obj.Object
+		}
+		history = append(history, entry)
+		prev = obj
+	}
+	return history, nil
+}
+
+func printHistory(out io.Writer, format string, history []historyEntry) error {
+	return printOutput(out, format, history, func(w io.Writer) {
+		for _, e := range history {
+			fmt.Fprintf(w, "%s  %s  %s\n", e.Time.Local().Format(time.RFC3339), restic.ShortID(e.Snapshot), e.Change)
+			printFieldChanges(w, e.Fields)
+			if e.Object != nil {
+				printValue(w, " ", e.Object)
+			}
+		}
+	})
+}
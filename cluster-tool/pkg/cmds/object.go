@@ -0,0 +1,239 @@
+package cmds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// shortNames maps the short names of common resources to their kinds
+var shortNames = map[string]string{
+	"cj":     "cronjob",
+	"cm":     "configmap",
+	"deploy": "deployment",
+	"ds":     "daemonset",
+	"ing":    "ingress",
+	"ns":     "namespace",
+	"po":     "pod",
+	"pv":     "persistentvolume",
+	"pvc":    "persistentvolumeclaim",
+	"rc":     "replicationcontroller",
+	"rs":     "replicaset",
+	"sa":     "serviceaccount",
+	"sts":    "statefulset",
+	"svc":    "service",
+}
+
+// objectRef is an object given as <resource>[.<group>]/<name> on the command line.
+// The resource may be given by its plural, singular or short name or by its kind.
+type objectRef struct {
+	resource  string
+	group     string
+	namespace string
+	name      string
+}
+
+func parseObjectRef(arg, namespace string) (objectRef, error) {
+	parts := strings.Split(arg, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return objectRef{}, fmt.Errorf("invalid object %q, use <resource>/<name> (i.e. deployments/api or deployments.apps/api)", arg)
+	}
+	ref := objectRef{
+		resource:  strings.ToLower(parts[0]),
+		namespace: namespace,
+		name:      parts[1],
+	}
+	if i := strings.Index(ref.resource, "."); i >= 0 {
+		ref.resource, ref.group = ref.resource[:i], ref.resource[i+1:]
+	}
+	if kind, ok := shortNames[ref.resource]; ok {
+		ref.resource = kind
+	}
+	return ref, nil
+}
+
+func (r objectRef) String() string {
+	resource := r.resource
+	if r.group != "" {
+		resource += "." + r.group
+	}
+	if r.namespace == "" {
+		return resource + "/" + r.name
+	}
+	return fmt.Sprintf("%s/%s -n %s", resource, r.name, r.namespace)
+}
+
+// matches reports whether the object identified by apiVersion, kind, namespace
+// and name is the referred object. Resources are matched by their kind, as
+// snapshots do not record the resource of an object. Cluster scoped objects
+// match in any namespace.
+func (r objectRef) matches(apiVersion, kind, namespace, name string) bool {
+	if name != r.name || namespace != "" && namespace != r.namespace {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return false
+	}
+	group := gv.Group
+	if group == "" {
+		group = "core"
+	}
+	if r.group != "" && r.group != group && !strings.HasPrefix(group, r.group+".") {
+		return false
+	}
+	kind = strings.ToLower(kind)
+	switch r.resource {
+	case kind, kind + "s", kind + "es":
+		return true
+	}
+	return strings.HasSuffix(kind, "y") && r.resource == strings.TrimSuffix(kind, "y")+"ies"
+}
+
+// snapshotReader reads single objects from a snapshot without restoring the whole snapshot
+type snapshotReader struct {
+	w        *restic.ResticWrapper
+	snapshot restic.Snapshot
+	files    []string
+	// manifest is nil for snapshots taken before manifests were written
+	manifest     *backup.Manifest
+	manifestFile string
+}
+
+func newSnapshotReader(w *restic.ResticWrapper, snapshot restic.Snapshot) (*snapshotReader, error) {
+	files, err := w.ListFiles(snapshot.ID)
+	if err != nil {
+		return nil, err
+	}
+	r := &snapshotReader{
+		w:        w,
+		snapshot: snapshot,
+		files:    files,
+	}
+
+	var manifests []string
+	for _, f := range files {
+		if path.Base(f) == backup.ManifestFile {
+			manifests = append(manifests, f)
+		}
+	}
+	if len(manifests) == 0 {
+		return r, nil
+	}
+	// a snapshot holds a single dump, like in restore.FindSnapshotDir
+	if len(manifests) > 1 {
+		return nil, fmt.Errorf("snapshot %s holds %d dumped snapshots: %s", snapshot.ShortID(), len(manifests), strings.Join(manifests, ", "))
+	}
+	r.manifestFile = manifests[0]
+
+	data, err := w.Dump(snapshot.ID, r.manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	r.manifest = &backup.Manifest{}
+	if err := json.Unmarshal(data, r.manifest); err != nil {
+		return nil, errors.Wrapf(err, "failed to read manifest of snapshot %s", snapshot.ShortID())
+	}
+	return r, nil
+}
+
+// find reads the referred object. It returns nil if the snapshot does not hold the object.
+func (r *snapshotReader) find(ref objectRef) (*unstructured.Unstructured, error) {
+	if r.manifest == nil {
+		return r.findByName(ref)
+	}
+
+	found := r.entries(func(entry backup.ManifestEntry) bool {
+		return ref.matches(entry.APIVersion, entry.Kind, entry.Namespace, entry.Name)
+	})
+	if len(found) == 0 {
+		return nil, nil
+	} else if len(found) > 1 {
+		return nil, ambiguousObjectError(ref, found)
+	}
+	return r.read(found[0])
+}
+
+// covers reports whether the snapshot could hold obj. Snapshots taken before
+// manifests were written are assumed to hold every object.
+func (r *snapshotReader) covers(obj *unstructured.Unstructured) bool {
+	return r.manifest == nil || r.manifest.Covers(obj)
+}
+
+// entries returns the manifest entries selected by match
+func (r *snapshotReader) entries(match func(entry backup.ManifestEntry) bool) []backup.ManifestEntry {
+	var found []backup.ManifestEntry
+	if r.manifest == nil {
+		return found
+	}
+	for _, entry := range r.manifest.Objects {
+		if match(entry) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// read reads the object of a manifest entry and verifies its checksum
+func (r *snapshotReader) read(entry backup.ManifestEntry) (*unstructured.Unstructured, error) {
+	data, err := r.w.Dump(r.snapshot.ID, path.Join(path.Dir(r.manifestFile), entry.Path))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != entry.SHA256 {
+		return nil, fmt.Errorf("checksum mismatch for %s in snapshot %s", entry.Path, r.snapshot.ShortID())
+	}
+	return restore.DecodeObject(data)
+}
+
+// findByName searches the files named after the object in snapshots taken
+// before manifests were written.
+func (r *snapshotReader) findByName(ref objectRef) (*unstructured.Unstructured, error) {
+	var found []*unstructured.Unstructured
+	for _, f := range r.files {
+		if path.Base(f) != ref.name+".yaml" && !strings.HasSuffix(f, "_"+ref.name+".yaml") {
+			continue
+		}
+		data, err := r.w.Dump(r.snapshot.ID, f)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := restore.DecodeObject(data)
+		if err != nil {
+			continue // not an object
+		}
+		if ref.matches(obj.GetAPIVersion(), obj.GetKind(), obj.GetNamespace(), obj.GetName()) {
+			found = append(found, obj)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+	entries := make([]backup.ManifestEntry, 0, len(found))
+	for _, obj := range found {
+		entries = append(entries, backup.ManifestEntry{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind()})
+	}
+	return nil, ambiguousObjectError(ref, entries)
+}
+
+func ambiguousObjectError(ref objectRef, found []backup.ManifestEntry) error {
+	var kinds []string
+	for _, entry := range found {
+		kinds = append(kinds, entry.APIVersion+" "+entry.Kind)
+	}
+	return fmt.Errorf("%s is ambiguous, it matches %s, specify the group as <resource>.<group>/<name>", ref, strings.Join(kinds, ", "))
+}
@@ -0,0 +1,103 @@
+package cmds
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type restoreOptions struct {
+	masterUrl      string
+	kubeconfigPath string
+	context        string
+	snapshot       string
+	privateKeyFile string
+	backup         restic.BackupOptions
+}
+
+func NewCmdRestore() *cobra.Command {
+
+	opt := restoreOptions{
+		snapshot: "latest",
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "restore",
+		Short:             "Restores Kubernetes api objects from a backup snapshot",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+
+			err := runRestore(&opt)
+			if err != nil {
+				return err
+			}
+			log.Infoln("Restore Successful")
+			return nil
+		},
+	}
+	addKubeFlags(cmd.Flags(), &opt.masterUrl, &opt.kubeconfigPath, &opt.context)
+	cmd.Flags().StringVar(&opt.snapshot, "snapshot", opt.snapshot, "ID of the snapshot to restore (use 'latest' for the most recent snapshot or 'latest~n' for the n-th snapshot before it)")
+	cmd.Flags().StringVar(&opt.privateKeyFile, "secrets-private-key-file", "", "File holding the X25519 private key to decrypt Secrets backed up with --secrets-mode=encrypt")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+func runRestore(opt *restoreOptions) error {
+	config, err := buildConfig(opt.masterUrl, opt.kubeconfigPath, opt.context)
+	if err != nil {
+		return err
+	}
+
+	var mgrOpt restore.Options
+	if opt.privateKeyFile != "" {
+		mgrOpt.SecretsPrivateKey, err = backup.ReadPrivateKey(opt.privateKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := newResticWrapper(&opt.backup)
+	if err != nil {
+		return err
+	}
+	snapshots, err := resolveSnapshots(w, &opt.backup, opt.snapshot)
+	if err != nil {
+		return err
+	}
+
+	// Restore into an empty directory, so that no other dump can be mistaken for the snapshot
+	if err := os.MkdirAll(opt.backup.ScratchDir, 0755); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(opt.backup.ScratchDir, "snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := w.RestoreSnapshot(snapshots[0].ID, dir); err != nil {
+		return err
+	}
+
+	snapshotDir, err := restore.FindSnapshotDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find restored snapshot %s", snapshots[0].ShortID())
+	}
+	log.Infoln("Applying objects from snapshot", snapshots[0].ShortID())
+
+	mgr := restore.NewRestoreManager(config, mgrOpt)
+	return mgr.RestoreFromDir(snapshotDir)
+}
@@ -0,0 +1,184 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscode/go/log"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/diff"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+type restoreObjectOptions struct {
+	masterUrl        string
+	kubeconfigPath   string
+	context          string
+	namespace        string
+	snapshot         string
+	withDependencies bool
+	apply            bool
+	privateKeyFile   string
+	backup           restic.BackupOptions
+}
+
+func NewCmdRestoreObject() *cobra.Command {
+
+	opt := restoreObjectOptions{
+		namespace: "default",
+		snapshot:  "latest",
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "restore-object <resource>/<name>",
+		Short:             "Prints or applies a single object from a backup snapshot",
+		Long:              "Prints a single object from a backup snapshot, or applies it to the cluster with --apply. The resource is given by its plural, singular or short name or its kind, optionally followed by its group (i.e. deployments.apps/api).",
+		Args:              cobra.ExactArgs(1),
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			ref, err := parseObjectRef(args[0], opt.namespace)
+			if err != nil {
+				return err
+			}
+
+			objects, err := readObjects(&opt, ref)
+			if err != nil {
+				return err
+			}
+			if !opt.apply {
+				return printObjects(os.Stdout, objects)
+			}
+			if err := applyObjects(&opt, objects); err != nil {
+				return err
+			}
+			log.Infoln("Restore Successful")
+			return nil
+		},
+	}
+	addKubeFlags(cmd.Flags(), &opt.masterUrl, &opt.kubeconfigPath, &opt.context)
+	cmd.Flags().StringVarP(&opt.namespace, "namespace", "n", opt.namespace, "Namespace of the object")
+	cmd.Flags().StringVar(&opt.snapshot, "snapshot", opt.snapshot, "ID of the snapshot to read the object from (use 'latest' for the most recent snapshot or 'latest~n' for the n-th snapshot before it)")
+	cmd.Flags().BoolVar(&opt.withDependencies, "with-dependencies", false, "Also read the ConfigMaps, Secrets, ServiceAccount and PersistentVolumeClaims the pod template of the object references and the Services selecting its pods")
+	cmd.Flags().BoolVar(&opt.apply, "apply", false, "Apply the objects to the cluster instead of printing them")
+	cmd.Flags().StringVar(&opt.privateKeyFile, "secrets-private-key-file", "", "File holding the X25519 private key to decrypt Secrets backed up with --secrets-mode=encrypt")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+// readObjects reads the referred object and, if requested, its dependencies from the snapshot
+func readObjects(opt *restoreObjectOptions, ref objectRef) ([]*unstructured.Unstructured, error) {
+	w, err := newResticWrapper(&opt.backup)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := resolveSnapshots(w, &opt.backup, opt.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := newSnapshotReader(w, snapshots[0])
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := reader.find(ref)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errors.Errorf("%s not found in snapshot %s", ref, snapshots[0].ShortID())
+	}
+	objects := []*unstructured.Unstructured{obj}
+	if !opt.withDependencies {
+		return objects, nil
+	}
+
+	deps, podLabels, err := restore.Dependencies(obj)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[diff.ObjectKey]bool{diff.KeyOf(obj): true}
+	add := func(o *unstructured.Unstructured) {
+		if !seen[diff.KeyOf(o)] {
+			seen[diff.KeyOf(o)] = true
+			objects = append(objects, o)
+		}
+	}
+	for _, dep := range deps {
+		depRef := objectRef{resource: strings.ToLower(dep.Kind), group: "core", namespace: obj.GetNamespace(), name: dep.Name}
+		o, err := reader.find(depRef)
+		if err != nil {
+			return nil, err
+		}
+		if o == nil {
+			// Secrets are missing from snapshots taken with --secrets-mode=skip
+			log.Warningf("%s %s is not in snapshot %s, skipping it", dep.Kind, dep.Name, snapshots[0].ShortID())
+			continue
+		}
+		add(o)
+	}
+
+	if podLabels == nil {
+		return objects, nil
+	}
+	if reader.manifest == nil {
+		log.Warningf("Snapshot %s has no manifest, Services selecting the pods are not searched", snapshots[0].ShortID())
+		return objects, nil
+	}
+	services := reader.entries(func(entry backup.ManifestEntry) bool {
+		return entry.APIVersion == "v1" && entry.Kind == "Service" && entry.Namespace == obj.GetNamespace()
+	})
+	for _, entry := range services {
+		svc, err := reader.read(entry)
+		if err != nil {
+			return nil, err
+		}
+		if restore.ServiceSelects(svc, podLabels) {
+			add(svc)
+		}
+	}
+	return objects, nil
+}
+
+func applyObjects(opt *restoreObjectOptions, objects []*unstructured.Unstructured) error {
+	config, err := buildConfig(opt.masterUrl, opt.kubeconfigPath, opt.context)
+	if err != nil {
+		return err
+	}
+	var mgrOpt restore.Options
+	if opt.privateKeyFile != "" {
+		mgrOpt.SecretsPrivateKey, err = backup.ReadPrivateKey(opt.privateKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+	return restore.NewRestoreManager(config, mgrOpt).Restore(objects)
+}
+
+// printObjects writes objects as a multi document YAML stream
+func printObjects(out io.Writer, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,6 +8,10 @@ import (
 
 var (
 	Sanitize bool
+
+	// Version of cluster-tool, set at build time with
+	// -ldflags "-X github.com/appscodelabs/actions/cluster-tool/pkg/cmds.Version=<version>"
+	Version = "canary"
 )
 
 func NewRootCmd() *cobra.Command {
@@ -15,6 +19,7 @@ func NewRootCmd() *cobra.Command {
 		Use:               "cluster-tool",
 		Short:             "cluster-tool by AppsCode - Backup cluster-tool yaml",
 		Long:              "cluster-tool is a tool to take restic cluster-tool's yaml using restic",
+		Version:           Version,
 		DisableAutoGenTag: true,
 	}
 
@@ -22,5 +27,14 @@ func NewRootCmd() *cobra.Command {
 	flag.CommandLine.Parse([]string{})
 
 	rootCmd.AddCommand(NewCmdBackup())
+	rootCmd.AddCommand(NewCmdRestore())
+	rootCmd.AddCommand(NewCmdRestoreObject())
+	rootCmd.AddCommand(NewCmdSnapshots())
+	rootCmd.AddCommand(NewCmdForget())
+	rootCmd.AddCommand(NewCmdDiff())
+	rootCmd.AddCommand(NewCmdDrift())
+	rootCmd.AddCommand(NewCmdHistory())
+	rootCmd.AddCommand(NewCmdClone())
+	rootCmd.AddCommand(NewCmdConvert())
 	return rootCmd
 }
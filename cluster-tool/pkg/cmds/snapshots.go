@@ -0,0 +1,166 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appscode/go/flags"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restic"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type snapshotsOptions struct {
+	tags   []string
+	since  string
+	until  string
+	output string
+	backup restic.BackupOptions
+}
+
+func NewCmdSnapshots() *cobra.Command {
+
+	opt := snapshotsOptions{
+		output: OutputTable,
+		backup: restic.BackupOptions{
+			ScratchDir:  "/tmp/restic/scratch",
+			EnableCache: false,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "snapshots",
+		Short:             "Lists the backup snapshots stored in the repository",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "path", "secret-dir")
+			if err := validateOutputFormat(opt.output); err != nil {
+				return err
+			}
+
+			filter := snapshotFilter(&opt.backup)
+			filter.Tags = append(filter.Tags, opt.tags...)
+			var err error
+			if filter.Since, err = parseTime(opt.since); err != nil {
+				return err
+			}
+			if filter.Until, err = parseTime(opt.until); err != nil {
+				return err
+			}
+
+			w, err := newResticWrapper(&opt.backup)
+			if err != nil {
+				return err
+			}
+			snapshots, err := listSnapshots(w, filter)
+			if err != nil {
+				return err
+			}
+			return printSnapshots(os.Stdout, opt.output, snapshots)
+		},
+	}
+	cmd.Flags().StringSliceVar(&opt.tags, "tag", nil, "List only the snapshots having all of these tags (i.e. partial)")
+	cmd.Flags().StringVar(&opt.since, "since", "", "List only the snapshots taken since this time, as an RFC3339 timestamp, a date or a duration (i.e. 72h)")
+	cmd.Flags().StringVar(&opt.until, "until", "", "List only the snapshots taken until this time, as an RFC3339 timestamp, a date or a duration (i.e. 24h)")
+	cmd.Flags().StringVarP(&opt.output, "output", "o", opt.output, "Output format: table, json or yaml")
+
+	addResticFlags(cmd.Flags(), &opt.backup)
+
+	return cmd
+}
+
+// newResticWrapper returns a ResticWrapper for the repository specified by opt
+func newResticWrapper(opt *restic.BackupOptions) (*restic.ResticWrapper, error) {
+	// Setup Environment variables for restic cli
+	w := restic.NewResticWrapper(opt.ScratchDir, opt.EnableCache, opt.Hostname)
+	err := w.SetupEnv(opt.Provider, opt.Bucket, opt.Endpoint, opt.Path, opt.SecretDir)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// listSnapshots returns the snapshots selected by filter, oldest first
+func listSnapshots(w *restic.ResticWrapper, filter restic.SnapshotFilter) ([]restic.Snapshot, error) {
+	snapshots, err := w.ListSnapshots(nil)
+	if err != nil {
+		return nil, err
+	}
+	return restic.FilterSnapshots(snapshots, filter), nil
+}
+
+// snapshotFilter selects the snapshots of --hostname and --cluster-name, if they are set
+func snapshotFilter(opt *restic.BackupOptions) restic.SnapshotFilter {
+	var filter restic.SnapshotFilter
+	if opt.Hostname != "" {
+		filter.Hosts = []string{opt.Hostname}
+	}
+	if opt.ClusterName != "" {
+		filter.Tags = []string{backup.ClusterTag(opt.ClusterName)}
+	}
+	return filter
+}
+
+// resolveSnapshots finds the snapshots refs refer to. Only the snapshots of
+// --hostname and --cluster-name are considered if they are set.
+func resolveSnapshots(w *restic.ResticWrapper, opt *restic.BackupOptions, refs ...string) ([]restic.Snapshot, error) {
+	snapshots, err := listSnapshots(w, snapshotFilter(opt))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]restic.Snapshot, 0, len(refs))
+	for _, ref := range refs {
+		s, err := restic.ResolveSnapshot(snapshots, ref)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// loadSnapshotObjects restores a snapshot into a temporary directory inside the
+// scratch directory and reads its metadata and dumped objects.
+func loadSnapshotObjects(w *restic.ResticWrapper, scratchDir string, snapshot restic.Snapshot) (*backup.SnapshotMetadata, []*unstructured.Unstructured, error) {
+	if err := os.MkdirAll(scratchDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	dir, err := ioutil.TempDir(scratchDir, "snapshot-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := w.RestoreSnapshot(snapshot.ID, dir); err != nil {
+		return nil, nil, err
+	}
+	snapshotDir, err := restore.FindSnapshotDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to find restored snapshot %s", snapshot.ShortID())
+	}
+	md, err := backup.ReadMetadata(snapshotDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects, err := restore.LoadObjects(snapshotDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return md, objects, nil
+}
+
+func printSnapshots(out io.Writer, format string, snapshots []restic.Snapshot) error {
+	return printOutput(out, format, snapshots, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tTIME\tHOST\tTAGS\tPATHS")
+		for _, s := range snapshots {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ShortID(), s.Time.Local().Format(time.RFC3339), s.Hostname, strings.Join(s.Tags, ","), strings.Join(s.Paths, ","))
+		}
+	})
+}
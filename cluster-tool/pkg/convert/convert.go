@@ -0,0 +1,352 @@
+package convert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// removal is a deprecated apiVersion of a kind that the API server stops serving
+type removal struct {
+	apiVersion string
+	// kind is empty if every kind of apiVersion is removed
+	kind string
+	// removedIn is the first minor version of Kubernetes 1.x not serving the apiVersion
+	removedIn int
+	// to is the apiVersion objects are converted to. It is empty if the kind
+	// cannot be converted, reason tells why.
+	to     string
+	reason string
+	// convert rewrites the fields that changed between the versions, it is nil
+	// if only the apiVersion changed
+	convert func(obj map[string]interface{}) error
+}
+
+// removals lists the apiVersions removed from Kubernetes, ref:
+// https://kubernetes.io/docs/reference/using-api/deprecation-guide/
+//
+// The conversions follow the upstream conversion functions of k8s.io/kubernetes.
+// They cannot be called through a scheme, as most target apiVersions are newer
+// than the Kubernetes 1.13 client libraries vendored here, and the internal
+// types of k8s.io/kubernetes cannot be vendored alongside them. Objects whose
+// target types are vendored are checked against them in the tests.
+var removals = []removal{
+	{apiVersion: "extensions/v1beta1", kind: "Deployment", removedIn: 16, to: "apps/v1", convert: toAppsV1},
+	{apiVersion: "extensions/v1beta1", kind: "ReplicaSet", removedIn: 16, to: "apps/v1", convert: toAppsV1},
+	{apiVersion: "extensions/v1beta1", kind: "DaemonSet", removedIn: 16, to: "apps/v1", convert: onDeleteToAppsV1},
+	{apiVersion: "extensions/v1beta1", kind: "NetworkPolicy", removedIn: 16, to: "networking.k8s.io/v1"},
+	{apiVersion: "extensions/v1beta1", kind: "PodSecurityPolicy", removedIn: 16, to: "policy/v1beta1"},
+	{apiVersion: "apps/v1beta1", kind: "StatefulSet", removedIn: 16, to: "apps/v1", convert: onDeleteToAppsV1},
+	{apiVersion: "apps/v1beta1", kind: "Deployment", removedIn: 16, to: "apps/v1", convert: toAppsV1},
+	{apiVersion: "apps/v1beta1", kind: "ControllerRevision", removedIn: 16, to: "apps/v1"},
+	{apiVersion: "apps/v1beta2", kind: "ControllerRevision", removedIn: 16, to: "apps/v1"},
+	{apiVersion: "apps/v1beta2", removedIn: 16, to: "apps/v1", convert: toAppsV1},
+
+	{apiVersion: "extensions/v1beta1", kind: "Ingress", removedIn: 22, to: "networking.k8s.io/v1", convert: toIngressV1},
+	{apiVersion: "networking.k8s.io/v1beta1", kind: "Ingress", removedIn: 22, to: "networking.k8s.io/v1", convert: toIngressV1},
+	{apiVersion: "networking.k8s.io/v1beta1", kind: "IngressClass", removedIn: 22, to: "networking.k8s.io/v1"},
+	{apiVersion: "rbac.authorization.k8s.io/v1alpha1", removedIn: 22, to: "rbac.authorization.k8s.io/v1"},
+	{apiVersion: "rbac.authorization.k8s.io/v1beta1", removedIn: 22, to: "rbac.authorization.k8s.io/v1"},
+	{apiVersion: "scheduling.k8s.io/v1alpha1", kind: "PriorityClass", removedIn: 22, to: "scheduling.k8s.io/v1"},
+	{apiVersion: "scheduling.k8s.io/v1beta1", kind: "PriorityClass", removedIn: 22, to: "scheduling.k8s.io/v1"},
+	{apiVersion: "coordination.k8s.io/v1beta1", kind: "Lease", removedIn: 22, to: "coordination.k8s.io/v1"},
+	{apiVersion: "apiregistration.k8s.io/v1beta1", kind: "APIService", removedIn: 22, to: "apiregistration.k8s.io/v1"},
+	{apiVersion: "storage.k8s.io/v1beta1", kind: "CSIDriver", removedIn: 22, to: "storage.k8s.io/v1"},
+	{apiVersion: "storage.k8s.io/v1beta1", kind: "CSINode", removedIn: 22, to: "storage.k8s.io/v1"},
+	{apiVersion: "storage.k8s.io/v1beta1", kind: "VolumeAttachment", removedIn: 22, to: "storage.k8s.io/v1"},
+	{apiVersion: "apiextensions.k8s.io/v1beta1", kind: "CustomResourceDefinition", removedIn: 22,
+		reason: "apiextensions.k8s.io/v1 requires a structural schema for every version"},
+	{apiVersion: "admissionregistration.k8s.io/v1beta1", removedIn: 22,
+		reason: "admissionregistration.k8s.io/v1 requires sideEffects and admissionReviewVersions to be chosen for every webhook"},
+	{apiVersion: "certificates.k8s.io/v1beta1", kind: "CertificateSigningRequest", removedIn: 22,
+		reason: "certificates.k8s.io/v1 requires a signerName"},
+
+	{apiVersion: "batch/v1beta1", kind: "CronJob", removedIn: 25, to: "batch/v1"},
+	{apiVersion: "policy/v1beta1", kind: "PodDisruptionBudget", removedIn: 25, to: "policy/v1", convert: toPodDisruptionBudgetV1},
+	{apiVersion: "policy/v1beta1", kind: "PodSecurityPolicy", removedIn: 25,
+		reason: "PodSecurityPolicy was removed without replacement, use Pod Security Admission"},
+	{apiVersion: "autoscaling/v2beta1", kind: "HorizontalPodAutoscaler", removedIn: 25, to: "autoscaling/v2", convert: toAutoscalingV2},
+	{apiVersion: "discovery.k8s.io/v1beta1", kind: "EndpointSlice", removedIn: 25, to: "discovery.k8s.io/v1", convert: toEndpointSliceV1},
+	{apiVersion: "events.k8s.io/v1beta1", kind: "Event", removedIn: 25, to: "events.k8s.io/v1"},
+	{apiVersion: "node.k8s.io/v1beta1", kind: "RuntimeClass", removedIn: 25, to: "node.k8s.io/v1"},
+
+	{apiVersion: "autoscaling/v2beta2", kind: "HorizontalPodAutoscaler", removedIn: 26, to: "autoscaling/v2"},
+	{apiVersion: "storage.k8s.io/v1beta1", kind: "CSIStorageCapacity", removedIn: 27, to: "storage.k8s.io/v1"},
+}
+
+// NotConvertibleError is returned for objects whose apiVersion is not served by
+// the target Kubernetes version and that cannot be converted automatically
+type NotConvertibleError struct {
+	APIVersion string
+	Kind       string
+	Reason     string
+}
+
+func (e *NotConvertibleError) Error() string {
+	return fmt.Sprintf("%s %s cannot be converted: %s", e.APIVersion, e.Kind, e.Reason)
+}
+
+// IsNotConvertibleError returns true if err indicates that an object cannot be converted
+func IsNotConvertibleError(err error) bool {
+	_, ok := err.(*NotConvertibleError)
+	return err != nil && ok
+}
+
+// ParseKubeVersion returns the minor version of a Kubernetes 1.x version given
+// as 1.<minor>[.<patch>], optionally prefixed with "v"
+func ParseKubeVersion(s string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid Kubernetes version %q, use 1.<minor> (i.e. 1.22)", s)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return 0, fmt.Errorf("invalid Kubernetes version %q, use 1.<minor> (i.e. 1.22)", s)
+	}
+	return minor, nil
+}
+
+// Object converts obj in place to an apiVersion served by Kubernetes
+// 1.<minor>. It returns false if obj is served as is. Objects are converted
+// step by step, i.e. extensions/v1beta1 PodSecurityPolicies are first moved to
+// policy/v1beta1, which is not served from 1.25 either.
+func Object(obj *unstructured.Unstructured, minor int) (bool, error) {
+	converted := false
+	for {
+		r, ok := findRemoval(obj.GetAPIVersion(), obj.GetKind(), minor)
+		if !ok {
+			return converted, nil
+		}
+		if r.to == "" {
+			return converted, &NotConvertibleError{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Reason: r.reason}
+		}
+		if r.convert != nil {
+			if err := r.convert(obj.Object); err != nil {
+				return converted, &NotConvertibleError{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Reason: err.Error()}
+			}
+		}
+		obj.SetAPIVersion(r.to)
+		converted = true
+	}
+}
+
+func findRemoval(apiVersion, kind string, minor int) (removal, bool) {
+	for _, r := range removals {
+		if r.apiVersion == apiVersion && (r.kind == "" || r.kind == kind) && r.removedIn <= minor {
+			return r, true
+		}
+	}
+	return removal{}, false
+}
+
+// toAppsV1 converts Deployments, DaemonSets, ReplicaSets and StatefulSets to apps/v1
+func toAppsV1(obj map[string]interface{}) error {
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	// apps/v1 requires a selector, the older versions defaulted it to the pod template labels
+	if _, ok := spec["selector"]; !ok {
+		labels, ok, _ := unstructured.NestedMap(spec, "template", "metadata", "labels")
+		if !ok || len(labels) == 0 {
+			return fmt.Errorf("apps/v1 requires spec.selector and the pod template has no labels to default it to")
+		}
+		spec["selector"] = map[string]interface{}{"matchLabels": labels}
+	}
+	// dropped from apps/v1
+	delete(spec, "rollbackTo")
+	delete(spec, "templateGeneration")
+	return nil
+}
+
+// onDeleteToAppsV1 converts DaemonSets and StatefulSets of versions that defaulted
+// the update strategy to OnDelete, apps/v1 defaults it to RollingUpdate.
+func onDeleteToAppsV1(obj map[string]interface{}) error {
+	if _, ok, _ := unstructured.NestedFieldNoCopy(obj, "spec", "updateStrategy"); !ok {
+		if err := unstructured.SetNestedField(obj, "OnDelete", "spec", "updateStrategy", "type"); err != nil {
+			return err
+		}
+	}
+	return toAppsV1(obj)
+}
+
+// toIngressV1 moves the service backends to the service field, renames backend
+// to defaultBackend and sets the path type, which networking.k8s.io/v1 requires.
+func toIngressV1(obj map[string]interface{}) error {
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if backend, ok := spec["backend"].(map[string]interface{}); ok {
+		if err := convertIngressBackend(backend); err != nil {
+			return err
+		}
+		spec["defaultBackend"] = backend
+		delete(spec, "backend")
+	}
+	rules, _ := spec["rules"].([]interface{})
+	for _, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		paths, _, _ := unstructured.NestedFieldNoCopy(rule, "http", "paths")
+		list, _ := paths.([]interface{})
+		for _, p := range list {
+			path, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := path["pathType"]; !ok {
+				path["pathType"] = "ImplementationSpecific"
+			}
+			if backend, ok := path["backend"].(map[string]interface{}); ok {
+				if err := convertIngressBackend(backend); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func convertIngressBackend(backend map[string]interface{}) error {
+	name, ok := backend["serviceName"].(string)
+	if !ok {
+		return nil // a resource backend
+	}
+	port := map[string]interface{}{}
+	switch p := backend["servicePort"].(type) {
+	case string:
+		if n, err := strconv.ParseInt(p, 10, 32); err == nil {
+			port["number"] = n
+		} else {
+			port["name"] = p
+		}
+	case int64, float64:
+		port["number"] = p
+	default:
+		return fmt.Errorf("invalid servicePort %v of backend %s", p, name)
+	}
+	delete(backend, "serviceName")
+	delete(backend, "servicePort")
+	backend["service"] = map[string]interface{}{"name": name, "port": port}
+	return nil
+}
+
+// toPodDisruptionBudgetV1 refuses budgets with an empty selector, which selects
+// no pods in policy/v1beta1 but every pod of the namespace in policy/v1.
+func toPodDisruptionBudgetV1(obj map[string]interface{}) error {
+	selector, _, _ := unstructured.NestedMap(obj, "spec", "selector")
+	if len(selector) == 0 {
+		return fmt.Errorf("its empty selector selects no pods in policy/v1beta1 but every pod in policy/v1")
+	}
+	return nil
+}
+
+// toAutoscalingV2 moves the targets of autoscaling/v2beta1 metrics to their
+// MetricTarget and the metric names to their MetricIdentifier. The type of the
+// target is chosen like the api server does when a metric sets several targets.
+func toAutoscalingV2(obj map[string]interface{}) error {
+	metrics, ok, _ := unstructured.NestedFieldNoCopy(obj, "spec", "metrics")
+	if !ok {
+		return nil
+	}
+	list, _ := metrics.([]interface{})
+	for _, m := range list {
+		metric, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		t, _ := metric["type"].(string)
+		if t == "" {
+			return fmt.Errorf("metric without type")
+		}
+		source, ok := metric[strings.ToLower(t[:1])+t[1:]].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("metric of type %q has no source", t)
+		}
+		target := map[string]interface{}{}
+		setTarget := func(field, from string) {
+			if v, ok := source[from]; ok && v != nil {
+				target[field] = v
+			}
+		}
+		switch t {
+		case "Resource", "ContainerResource":
+			setTarget("averageUtilization", "targetAverageUtilization")
+			setTarget("averageValue", "targetAverageValue")
+			target["type"] = "AverageValue"
+			if target["averageUtilization"] != nil {
+				target["type"] = "Utilization"
+			}
+		case "Pods":
+			setTarget("averageValue", "targetAverageValue")
+			target["type"] = "AverageValue"
+		case "Object":
+			setTarget("value", "targetValue")
+			setTarget("averageValue", "averageValue")
+			target["type"] = "Value"
+			if target["averageValue"] != nil {
+				target["type"] = "AverageValue"
+			}
+		case "External":
+			setTarget("value", "targetValue")
+			setTarget("averageValue", "targetAverageValue")
+			target["type"] = "AverageValue"
+			if target["value"] != nil {
+				target["type"] = "Value"
+			}
+		}
+		for _, field := range []string{"targetAverageUtilization", "targetAverageValue", "averageValue", "targetValue"} {
+			delete(source, field)
+		}
+
+		switch t {
+		case "Object":
+			source["describedObject"] = source["target"]
+			fallthrough
+		case "Pods", "External":
+			identifier := map[string]interface{}{"name": source["metricName"]}
+			if s, ok := source["selector"]; ok {
+				identifier["selector"] = s
+			}
+			if s, ok := source["metricSelector"]; ok {
+				identifier["selector"] = s
+			}
+			for _, field := range []string{"metricName", "selector", "metricSelector"} {
+				delete(source, field)
+			}
+			source["metric"] = identifier
+		}
+		source["target"] = target
+	}
+	return nil
+}
+
+// toEndpointSliceV1 replaces the topology of endpoints with the nodeName and zone fields
+func toEndpointSliceV1(obj map[string]interface{}) error {
+	endpoints, _ := obj["endpoints"].([]interface{})
+	for _, e := range endpoints {
+		endpoint, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		topology, ok := endpoint["topology"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if v, ok := topology["kubernetes.io/hostname"]; ok {
+			endpoint["nodeName"] = v
+			delete(topology, "kubernetes.io/hostname")
+		}
+		if v, ok := topology["topology.kubernetes.io/zone"]; ok {
+			endpoint["zone"] = v
+			delete(topology, "topology.kubernetes.io/zone")
+		}
+		delete(endpoint, "topology")
+		if len(topology) > 0 {
+			endpoint["deprecatedTopology"] = topology
+		}
+	}
+	return nil
+}
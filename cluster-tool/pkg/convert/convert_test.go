@@ -0,0 +1,545 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	apps "k8s.io/api/apps/v1"
+	networking "k8s.io/api/networking/v1"
+	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// typedObjects are the types of the target apiVersions vendored with the
+// client libraries. Converted objects of these kinds must decode into them
+// without unknown fields.
+var typedObjects = map[string]func() interface{}{
+	"apps/v1 Deployment":                              func() interface{} { return &apps.Deployment{} },
+	"apps/v1 DaemonSet":                               func() interface{} { return &apps.DaemonSet{} },
+	"apps/v1 StatefulSet":                             func() interface{} { return &apps.StatefulSet{} },
+	"apps/v1 ReplicaSet":                              func() interface{} { return &apps.ReplicaSet{} },
+	"networking.k8s.io/v1 NetworkPolicy":              func() interface{} { return &networking.NetworkPolicy{} },
+	"rbac.authorization.k8s.io/v1 ClusterRole":        func() interface{} { return &rbac.ClusterRole{} },
+	"rbac.authorization.k8s.io/v1 RoleBinding":        func() interface{} { return &rbac.RoleBinding{} },
+	"rbac.authorization.k8s.io/v1 ClusterRoleBinding": func() interface{} { return &rbac.ClusterRoleBinding{} },
+}
+
+func decodeObject(t *testing.T, s string) *unstructured.Unstructured {
+	obj, err := restore.DecodeObject([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestObject(t *testing.T) {
+	cases := []struct {
+		name      string
+		minor     int
+		in        string
+		want      string
+		converted bool
+		wantErr   bool
+	}{
+		{
+			name:      "deployment defaults the selector and drops rollbackTo",
+			minor:     16,
+			converted: true,
+			in: `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata: {name: api, namespace: payments}
+spec:
+  replicas: 2
+  rollbackTo: {revision: 3}
+  templateGeneration: 4
+  template:
+    metadata:
+      labels: {app: api}
+    spec:
+      containers:
+      - {name: api, image: "api:1.0"}
+`,
+			want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: api, namespace: payments}
+spec:
+  replicas: 2
+  selector:
+    matchLabels: {app: api}
+  template:
+    metadata:
+      labels: {app: api}
+    spec:
+      containers:
+      - {name: api, image: "api:1.0"}
+`,
+		},
+		{
+			name:  "deployment served by the target version",
+			minor: 15,
+			in: `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata: {name: api, namespace: payments}
+spec:
+  template:
+    metadata:
+      labels: {app: api}
+`,
+			want: `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata: {name: api, namespace: payments}
+spec:
+  template:
+    metadata:
+      labels: {app: api}
+`,
+		},
+		{
+			name:    "deployment without selector and labels",
+			minor:   16,
+			wantErr: true,
+			in: `
+apiVersion: apps/v1beta1
+kind: Deployment
+metadata: {name: api, namespace: payments}
+spec:
+  template:
+    spec:
+      containers:
+      - {name: api, image: "api:1.0"}
+`,
+		},
+		{
+			name:      "daemonset keeps the OnDelete update strategy",
+			minor:     16,
+			converted: true,
+			in: `
+apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata: {name: agent, namespace: monitoring}
+spec:
+  selector:
+    matchLabels: {app: agent}
+  template:
+    metadata:
+      labels: {app: agent}
+`,
+			want: `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata: {name: agent, namespace: monitoring}
+spec:
+  selector:
+    matchLabels: {app: agent}
+  updateStrategy: {type: OnDelete}
+  template:
+    metadata:
+      labels: {app: agent}
+`,
+		},
+		{
+			name:      "statefulset with an update strategy",
+			minor:     16,
+			converted: true,
+			in: `
+apiVersion: apps/v1beta1
+kind: StatefulSet
+metadata: {name: db, namespace: payments}
+spec:
+  serviceName: db
+  updateStrategy: {type: RollingUpdate}
+  template:
+    metadata:
+      labels: {app: db}
+`,
+			want: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: db, namespace: payments}
+spec:
+  serviceName: db
+  selector:
+    matchLabels: {app: db}
+  updateStrategy: {type: RollingUpdate}
+  template:
+    metadata:
+      labels: {app: db}
+`,
+		},
+		{
+			name:      "apps/v1beta2 replicaset",
+			minor:     16,
+			converted: true,
+			in: `
+apiVersion: apps/v1beta2
+kind: ReplicaSet
+metadata: {name: api-6d4cf56db6, namespace: payments}
+spec:
+  selector:
+    matchLabels: {app: api}
+`,
+			want: `
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata: {name: api-6d4cf56db6, namespace: payments}
+spec:
+  selector:
+    matchLabels: {app: api}
+`,
+		},
+		{
+			name:      "network policy",
+			minor:     16,
+			converted: true,
+			in: `
+apiVersion: extensions/v1beta1
+kind: NetworkPolicy
+metadata: {name: deny, namespace: payments}
+spec:
+  podSelector: {}
+  policyTypes: [Ingress]
+`,
+			want: `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata: {name: deny, namespace: payments}
+spec:
+  podSelector: {}
+  policyTypes: [Ingress]
+`,
+		},
+		{
+			name:      "ingress backends and path types",
+			minor:     22,
+			converted: true,
+			in: `
+apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata: {name: web, namespace: payments}
+spec:
+  backend: {serviceName: default, servicePort: 80}
+  rules:
+  - host: shop.example.com
+    http:
+      paths:
+      - path: /
+        backend: {serviceName: web, servicePort: http}
+      - path: /api
+        pathType: Prefix
+        backend: {serviceName: api, servicePort: "8080"}
+      - path: /static
+        backend:
+          resource: {apiGroup: storage.example.com, kind: Bucket, name: static}
+`,
+			want: `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata: {name: web, namespace: payments}
+spec:
+  defaultBackend:
+    service: {name: default, port: {number: 80}}
+  rules:
+  - host: shop.example.com
+    http:
+      paths:
+      - path: /
+        pathType: ImplementationSpecific
+        backend:
+          service: {name: web, port: {name: http}}
+      - path: /api
+        pathType: Prefix
+        backend:
+          service: {name: api, port: {number: 8080}}
+      - path: /static
+        pathType: ImplementationSpecific
+        backend:
+          resource: {apiGroup: storage.example.com, kind: Bucket, name: static}
+`,
+		},
+		{
+			name:      "rbac roles of a removed group version",
+			minor:     22,
+			converted: true,
+			in: `
+apiVersion: rbac.authorization.k8s.io/v1beta1
+kind: ClusterRole
+metadata: {name: reader}
+rules:
+- apiGroups: [""]
+  resources: [pods]
+  verbs: [get, list]
+`,
+			want: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata: {name: reader}
+rules:
+- apiGroups: [""]
+  resources: [pods]
+  verbs: [get, list]
+`,
+		},
+		{
+			name:    "v1beta1 custom resource definition",
+			minor:   22,
+			wantErr: true,
+			in: `
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata: {name: buckets.storage.example.com}
+`,
+		},
+		{
+			name:      "pod disruption budget",
+			minor:     25,
+			converted: true,
+			in: `
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata: {name: api, namespace: payments}
+spec:
+  minAvailable: 1
+  selector:
+    matchLabels: {app: api}
+`,
+			want: `
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata: {name: api, namespace: payments}
+spec:
+  minAvailable: 1
+  selector:
+    matchLabels: {app: api}
+`,
+		},
+		{
+			name:    "pod disruption budget with an empty selector",
+			minor:   25,
+			wantErr: true,
+			in: `
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata: {name: api, namespace: payments}
+spec:
+  minAvailable: 1
+`,
+		},
+		{
+			name:      "pod security policy removed after moving to policy",
+			minor:     25,
+			converted: true,
+			wantErr:   true,
+			in: `
+apiVersion: extensions/v1beta1
+kind: PodSecurityPolicy
+metadata: {name: restricted}
+`,
+		},
+		{
+			name:      "horizontal pod autoscaler metrics",
+			minor:     25,
+			converted: true,
+			in: `
+apiVersion: autoscaling/v2beta1
+kind: HorizontalPodAutoscaler
+metadata: {name: api, namespace: payments}
+spec:
+  scaleTargetRef: {apiVersion: apps/v1, kind: Deployment, name: api}
+  minReplicas: 2
+  maxReplicas: 10
+  metrics:
+  - type: Resource
+    resource: {name: cpu, targetAverageUtilization: 80}
+  - type: Pods
+    pods: {metricName: packets-per-second, targetAverageValue: 1k}
+  - type: Object
+    object:
+      target: {apiVersion: networking.k8s.io/v1, kind: Ingress, name: web}
+      metricName: requests-per-second
+      targetValue: 2k
+  - type: External
+    external:
+      metricName: queue-length
+      metricSelector:
+        matchLabels: {queue: orders}
+      targetAverageValue: "30"
+`,
+			want: `
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata: {name: api, namespace: payments}
+spec:
+  scaleTargetRef: {apiVersion: apps/v1, kind: Deployment, name: api}
+  minReplicas: 2
+  maxReplicas: 10
+  metrics:
+  - type: Resource
+    resource:
+      name: cpu
+      target: {type: Utilization, averageUtilization: 80}
+  - type: Pods
+    pods:
+      metric: {name: packets-per-second}
+      target: {type: AverageValue, averageValue: 1k}
+  - type: Object
+    object:
+      describedObject: {apiVersion: networking.k8s.io/v1, kind: Ingress, name: web}
+      metric: {name: requests-per-second}
+      target: {type: Value, value: 2k}
+  - type: External
+    external:
+      metric:
+        name: queue-length
+        selector:
+          matchLabels: {queue: orders}
+      target: {type: AverageValue, averageValue: "30"}
+`,
+		},
+		{
+			name:      "endpoint slice topology",
+			minor:     25,
+			converted: true,
+			in: `
+apiVersion: discovery.k8s.io/v1beta1
+kind: EndpointSlice
+metadata: {name: api-x7k2p, namespace: payments}
+addressType: IPv4
+endpoints:
+- addresses: [10.0.0.1]
+  topology:
+    kubernetes.io/hostname: node-1
+    topology.kubernetes.io/zone: zone-a
+    topology.kubernetes.io/region: region-1
+`,
+			want: `
+apiVersion: discovery.k8s.io/v1
+kind: EndpointSlice
+metadata: {name: api-x7k2p, namespace: payments}
+addressType: IPv4
+endpoints:
+- addresses: [10.0.0.1]
+  nodeName: node-1
+  zone: zone-a
+  deprecatedTopology:
+    topology.kubernetes.io/region: region-1
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			obj := decodeObject(t, c.in)
+			converted, err := Object(obj, c.minor)
+			if converted != c.converted {
+				t.Errorf("converted = %v, want %v", converted, c.converted)
+			}
+			if c.wantErr {
+				if !IsNotConvertibleError(err) {
+					t.Errorf("expected a NotConvertibleError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := decodeObject(t, c.want)
+			if !reflect.DeepEqual(obj.Object, want.Object) {
+				got, _ := yaml.Marshal(obj.Object)
+				t.Errorf("converted to\n%s\nwant\n%s", got, c.want)
+			}
+
+			newObject, ok := typedObjects[obj.GetAPIVersion()+" "+obj.GetKind()]
+			if !ok {
+				return
+			}
+			data, err := obj.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(newObject()); err != nil {
+				t.Errorf("converted object does not decode into its type: %v", err)
+			}
+		})
+	}
+}
+
+// TestObjectGolden compares converted objects with the objects converted by
+// the defaulting and conversion functions of kube-apiserver, which the golden
+// files of testdata were generated with. The server sets no status and no
+// creationTimestamp on these objects, they are left out of the golden files.
+func TestObjectGolden(t *testing.T) {
+	cases := []struct {
+		in     string
+		minor  int
+		golden string
+	}{
+		{in: "autoscaling-v2beta1.yaml", minor: 25, golden: "autoscaling-v2.golden.yaml"},
+		{in: "ingress-v1beta1.yaml", minor: 22, golden: "ingress-v1.golden.yaml"},
+		{in: "ingress-extensions-v1beta1.yaml", minor: 22, golden: "ingress-v1.golden.yaml"},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			in := readObjects(t, c.in)
+			want := readObjects(t, c.golden)
+			if len(in) != len(want) {
+				t.Fatalf("%s holds %d objects, %s holds %d", c.in, len(in), c.golden, len(want))
+			}
+			for i, obj := range in {
+				if _, err := Object(obj, c.minor); err != nil {
+					t.Fatalf("%s: %v", obj.GetName(), err)
+				}
+				if !reflect.DeepEqual(obj.Object, want[i].Object) {
+					got, _ := yaml.Marshal(obj.Object)
+					expected, _ := yaml.Marshal(want[i].Object)
+					t.Errorf("%s converted to\n%s\nwant\n%s", obj.GetName(), got, expected)
+				}
+			}
+		})
+	}
+}
+
+func readObjects(t *testing.T, name string) []*unstructured.Unstructured {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []*unstructured.Unstructured
+	for _, doc := range strings.Split(string(data), "\n---\n") {
+		objects = append(objects, decodeObject(t, doc))
+	}
+	return objects
+}
+
+func TestParseKubeVersion(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "1.22", want: 22},
+		{in: "v1.25.3", want: 25},
+		{in: "1", wantErr: true},
+		{in: "2.1", wantErr: true},
+		{in: "1.x", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseKubeVersion(c.in)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("ParseKubeVersion(%q) = %d, %v", c.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package convert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/appscodelabs/actions/cluster-tool/pkg/restore"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Result is an object that was converted or failed to be converted
+type Result struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Path of the object file relative to the snapshot directory
+	Path string `json:"path"`
+	// To is the apiVersion the object was converted to
+	To string `json:"to,omitempty"`
+	// Error tells why the object could not be converted
+	Error string `json:"error,omitempty"`
+}
+
+// Report lists the objects of a snapshot that were converted and the ones that
+// could not be converted
+type Report struct {
+	KubeVersion string   `json:"kubeVersion"`
+	Converted   []Result `json:"converted"`
+	Failed      []Result `json:"failed"`
+}
+
+// Dir converts the objects dumped in snapshotDir in place to apiVersions served
+// by Kubernetes 1.<minor>. Object files keep their paths. The manifest, if
+// the snapshot has one, is updated with the new apiVersions and checksums.
+// Objects that cannot be converted are left untouched and listed in the report.
+func Dir(snapshotDir string, minor int) (*Report, error) {
+	manifest, err := backup.ReadManifest(snapshotDir)
+	if os.IsNotExist(err) {
+		manifest = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	if manifest != nil {
+		for _, entry := range manifest.Objects {
+			paths = append(paths, entry.Path)
+		}
+	} else {
+		paths, err = objectFiles(snapshotDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &Report{
+		KubeVersion: fmt.Sprintf("1.%d", minor),
+		Converted:   []Result{},
+		Failed:      []Result{},
+	}
+	for i, p := range paths {
+		file := filepath.Join(snapshotDir, filepath.FromSlash(p))
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := restore.DecodeObject(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", p)
+		}
+		result := Result{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			Path:       p,
+		}
+
+		converted, err := Object(obj, minor)
+		if IsNotConvertibleError(err) {
+			result.Error = err.(*NotConvertibleError).Reason
+			report.Failed = append(report.Failed, result)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !converted {
+			continue
+		}
+
+		data, err = yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			sum := sha256.Sum256(data)
+			entry := &manifest.Objects[i]
+			entry.APIVersion = obj.GetAPIVersion()
+			entry.SHA256 = hex.EncodeToString(sum[:])
+			entry.Size = int64(len(data))
+		}
+		result.To = obj.GetAPIVersion()
+		report.Converted = append(report.Converted, result)
+	}
+
+	if manifest != nil && len(report.Converted) > 0 {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(snapshotDir, backup.ManifestFile), data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// objectFiles returns the object files of snapshots taken before manifests were written
+func objectFiles(snapshotDir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(snapshotDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".yaml") {
+			return nil
+		}
+		rel, err := filepath.Rel(snapshotDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == backup.ResourceListsFile || rel == backup.MetadataFile {
+			return nil
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
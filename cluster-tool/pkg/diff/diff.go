@@ -0,0 +1,317 @@
+package diff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+
+	// hiddenValue replaces the values of redacted and encrypted Secrets
+	hiddenValue = "<hidden>"
+)
+
+// ObjectKey identifies an object by its apiVersion, kind, namespace and name
+type ObjectKey struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+func KeyOf(obj *unstructured.Unstructured) ObjectKey {
+	return ObjectKey{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+func (k ObjectKey) String() string {
+	if k.Namespace == "" {
+		return fmt.Sprintf("%s %s %s", k.APIVersion, k.Kind, k.Name)
+	}
+	return fmt.Sprintf("%s %s %s/%s", k.APIVersion, k.Kind, k.Namespace, k.Name)
+}
+
+func (k ObjectKey) less(o ObjectKey) bool {
+	if k.APIVersion != o.APIVersion {
+		return k.APIVersion < o.APIVersion
+	}
+	if k.Kind != o.Kind {
+		return k.Kind < o.Kind
+	}
+	if k.Namespace != o.Namespace {
+		return k.Namespace < o.Namespace
+	}
+	return k.Name < o.Name
+}
+
+// ObjectDiff is an object added, removed or modified between two sets of objects
+type ObjectDiff struct {
+	ObjectKey `json:",inline"`
+	// Change is one of added, removed or modified
+	Change string `json:"change"`
+	// Fields lists the changed fields of a modified object
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a field that differs between two versions of an object. Old is
+// nil for added fields and New is nil for removed fields.
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// Normalize returns a sanitized copy of obj, so that objects backed up with and
+// without --sanitize compare equal. The values of redacted and encrypted Secrets
+// are hidden, encrypted values differ on every backup. The values of other
+// Secrets are replaced by their hash unless showSecrets is set, so that changes
+// are still reported without printing the values.
+func Normalize(obj *unstructured.Unstructured, showSecrets bool) (*unstructured.Unstructured, error) {
+	out := obj.DeepCopy()
+	if err := backup.Sanitize(out.Object); err != nil {
+		return nil, err
+	}
+	switch {
+	case backup.IsEncryptedSecret(out.Object) || backup.IsRedactedSecret(out.Object):
+		maskSecretData(out.Object, func(string) string { return hiddenValue })
+		annotations := out.GetAnnotations()
+		delete(annotations, backup.SecretDataAnnotation)
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		out.SetAnnotations(annotations)
+	case isSecret(out.Object) && !showSecrets:
+		maskSecretData(out.Object, hashValue)
+	}
+	return out, nil
+}
+
+func isSecret(obj map[string]interface{}) bool {
+	return obj["apiVersion"] == "v1" && obj["kind"] == "Secret"
+}
+
+// maskSecretData replaces the data and stringData values of a Secret
+func maskSecretData(obj map[string]interface{}, mask func(string) string) {
+	for _, field := range []string{"data", "stringData"} {
+		if data, ok := obj[field].(map[string]interface{}); ok {
+			for k, v := range data {
+				s, _ := v.(string)
+				data[k] = mask(s)
+			}
+		}
+	}
+}
+
+// hashValue returns a short sha256 hash of a Secret value
+func hashValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return "sha256:" + hex.EncodeToString(sum[:])[:16]
+}
+
+// Objects compares two sets of objects after normalizing them. The result is
+// sorted by apiVersion, kind, namespace and name.
+func Objects(old, new []*unstructured.Unstructured, showSecrets bool) ([]ObjectDiff, error) {
+	oldObjects, err := index(old, showSecrets)
+	if err != nil {
+		return nil, err
+	}
+	newObjects, err := index(new, showSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ObjectDiff
+	for key, o := range oldObjects {
+		n, ok := newObjects[key]
+		if !ok {
+			result = append(result, ObjectDiff{ObjectKey: key, Change: Removed})
+			continue
+		}
+		if fields := Fields(o.Object, n.Object); len(fields) > 0 {
+			result = append(result, ObjectDiff{ObjectKey: key, Change: Modified, Fields: fields})
+		}
+	}
+	for key := range newObjects {
+		if _, ok := oldObjects[key]; !ok {
+			result = append(result, ObjectDiff{ObjectKey: key, Change: Added})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ObjectKey.less(result[j].ObjectKey)
+	})
+	return result, nil
+}
+
+func index(objects []*unstructured.Unstructured, showSecrets bool) (map[ObjectKey]*unstructured.Unstructured, error) {
+	m := make(map[ObjectKey]*unstructured.Unstructured, len(objects))
+	for _, obj := range objects {
+		n, err := Normalize(obj, showSecrets)
+		if err != nil {
+			return nil, err
+		}
+		m[KeyOf(obj)] = n
+	}
+	return m, nil
+}
+
+// Fields compares two versions of an object field by field. Lists whose items
+// all have a unique name are compared by name, other lists by index.
+func Fields(old, new map[string]interface{}) []FieldChange {
+	var changes []FieldChange
+	compare("", old, new, &changes)
+	return changes
+}
+
+func compare(path string, old, new interface{}, changes *[]FieldChange) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(o)+len(n))
+			for k := range o {
+				keys = append(keys, k)
+			}
+			for k := range n {
+				if _, ok := o[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				compare(fieldPath(path, k), o[k], n[k], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok {
+			compareLists(path, o, n, changes)
+			return
+		}
+	}
+	if !equal(old, new) {
+		*changes = append(*changes, FieldChange{Path: path, Old: old, New: new})
+	}
+}
+
+func compareLists(path string, old, new []interface{}, changes *[]FieldChange) {
+	oldNames, okOld := itemNames(old)
+	newNames, okNew := itemNames(new)
+	if okOld && okNew {
+		for i, name := range oldNames {
+			item := fmt.Sprintf("%s[name=%s]", path, name)
+			j, ok := indexOf(newNames, name)
+			if !ok {
+				compare(item, old[i], nil, changes)
+				continue
+			}
+			compare(item, old[i], new[j], changes)
+		}
+		for j, name := range newNames {
+			if _, ok := indexOf(oldNames, name); !ok {
+				compare(fmt.Sprintf("%s[name=%s]", path, name), nil, new[j], changes)
+			}
+		}
+		return
+	}
+
+	for i := 0; i < len(old) || i < len(new); i++ {
+		var o, n interface{}
+		if i < len(old) {
+			o = old[i]
+		}
+		if i < len(new) {
+			n = new[i]
+		}
+		compare(fmt.Sprintf("%s[%d]", path, i), o, n, changes)
+	}
+}
+
+// itemNames returns the names of the items of a list, if every item is an
+// object with a unique name.
+func itemNames(list []interface{}) ([]string, bool) {
+	names := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok || seen[name] {
+			return nil, false
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, len(names) > 0
+}
+
+func indexOf(list []string, s string) (int, bool) {
+	for i, v := range list {
+		if v == s {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// equal compares two values. Numbers are compared by their value, as objects
+// decoded from YAML and from the API server may use different number types.
+func equal(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	if isNumber(a) && isNumber(b) {
+		return fmt.Sprint(a) == fmt.Sprint(b)
+	}
+	return false
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int32, int64, float32, float64, json.Number:
+		return true
+	}
+	return false
+}
+
+var plainKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func fieldPath(path, key string) string {
+	if !plainKey.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// DriftError is returned if the live objects differ from a snapshot
+type DriftError struct {
+	Diffs int
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("%d objects drifted from the snapshot", e.Diffs)
+}
+
+// IsDriftError returns true if err indicates that the live objects differ from a snapshot
+func IsDriftError(err error) bool {
+	_, ok := err.(*DriftError)
+	return err != nil && ok
+}
@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func secret(name string, data map[string]interface{}, annotations map[string]interface{}) *unstructured.Unstructured {
+	metadata := map[string]interface{}{"namespace": "default", "name": name}
+	if annotations != nil {
+		metadata["annotations"] = annotations
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   metadata,
+		"data":       data,
+		"stringData": map[string]interface{}{"token": "plain"},
+	}}
+}
+
+func TestNormalizeSecrets(t *testing.T) {
+	cases := []struct {
+		name        string
+		obj         *unstructured.Unstructured
+		showSecrets bool
+		masked      bool
+		hidden      bool
+	}{
+		{
+			name:   "values are hashed by default",
+			obj:    secret("s", map[string]interface{}{"password": "aHVudGVyMg=="}, nil),
+			masked: true,
+		},
+		{
+			name:        "values are shown on request",
+			obj:         secret("s", map[string]interface{}{"password": "aHVudGVyMg=="}, nil),
+			showSecrets: true,
+		},
+		{
+			name:        "redacted values stay hidden",
+			obj:         secret("s", map[string]interface{}{"password": ""}, map[string]interface{}{backup.SecretDataAnnotation: backup.SecretDataRedacted}),
+			showSecrets: true,
+			hidden:      true,
+		},
+		{
+			name:        "encrypted values stay hidden",
+			obj:         secret("s", map[string]interface{}{"password": "enc:v1:abc"}, map[string]interface{}{backup.SecretDataAnnotation: backup.SecretDataEncrypted}),
+			showSecrets: true,
+			hidden:      true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := Normalize(c.obj, c.showSecrets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range []string{"data", "stringData"} {
+				values, _, _ := unstructured.NestedStringMap(out.Object, field)
+				orig, _, _ := unstructured.NestedStringMap(c.obj.Object, field)
+				for k, v := range values {
+					switch {
+					case c.hidden:
+						if v != hiddenValue {
+							t.Errorf("%s.%s = %q, want %q", field, k, v, hiddenValue)
+						}
+					case c.masked:
+						if v != hashValue(orig[k]) || !strings.HasPrefix(v, "sha256:") {
+							t.Errorf("%s.%s = %q, want the hash of %q", field, k, v, orig[k])
+						}
+					default:
+						if v != orig[k] {
+							t.Errorf("%s.%s = %q, want %q", field, k, v, orig[k])
+						}
+					}
+				}
+			}
+			if _, ok := out.GetAnnotations()[backup.SecretDataAnnotation]; ok {
+				t.Errorf("annotation %s was not removed", backup.SecretDataAnnotation)
+			}
+		})
+	}
+}
+
+func TestObjectsMasksSecrets(t *testing.T) {
+	old := []*unstructured.Unstructured{secret("s", map[string]interface{}{"password": "b2xk"}, nil)}
+	new := []*unstructured.Unstructured{secret("s", map[string]interface{}{"password": "bmV3"}, nil)}
+
+	diffs, err := Objects(old, new, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || len(diffs[0].Fields) != 1 {
+		t.Fatalf("got %+v, want one modified field", diffs)
+	}
+	f := diffs[0].Fields[0]
+	if f.Path != "data.password" || f.Old != hashValue("b2xk") || f.New != hashValue("bmV3") {
+		t.Errorf("got %+v, want hashed values of data.password", f)
+	}
+
+	if diffs, err = Objects(old, old, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("got %+v for unchanged Secrets, want no diffs", diffs)
+	}
+
+	if diffs, err = Objects(old, new, true); err != nil {
+		t.Fatal(err)
+	}
+	if f := diffs[0].Fields[0]; f.Old != "b2xk" || f.New != "bmV3" {
+		t.Errorf("got %+v, want the values with showSecrets", f)
+	}
+}
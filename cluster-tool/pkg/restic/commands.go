@@ -37,8 +37,11 @@ func (w *ResticWrapper) ListSnapshots(snapshotIDs []string) ([]Snapshot, error)
 	return result, err
 }
 
-func (w *ResticWrapper) DeleteSnapshots(snapshotIDs []string) ([]byte, error) {
-	args := w.appendCacheDirFlag([]interface{}{"forget", "--quiet", "--prune"})
+func (w *ResticWrapper) DeleteSnapshots(snapshotIDs []string, prune bool) ([]byte, error) {
+	args := w.appendCacheDirFlag([]interface{}{"forget", "--quiet"})
+	if prune {
+		args = append(args, "--prune")
+	}
 	args = w.appendCaCertFlag(args)
 	for _, id := range snapshotIDs {
 		args = append(args, id)
@@ -62,7 +65,7 @@ func (w *ResticWrapper) InitRepositoryIfAbsent() ([]byte, error) {
 
 func (w *ResticWrapper) Backup(path string, tags []string) ([]byte, error) {
 	log.Infoln("Backing up target data")
-	args := []interface{}{"backup", path}
+	args := []interface{}{"backup", path, "--json"}
 	if w.hostname != "" {
 		args = append(args, "--host")
 		args = append(args, w.hostname)
@@ -78,33 +81,36 @@ func (w *ResticWrapper) Backup(path string, tags []string) ([]byte, error) {
 	return w.run(Exe, args)
 }
 
-func (w *ResticWrapper) Cleanup(policy, value string, prune, dryRun bool) ([]byte, error) {
+func (w *ResticWrapper) Cleanup(policy RetentionPolicy) ([]byte, error) {
 	log.Infoln("Cleaning old snapshots according to retention policy")
 
-	args := []interface{}{"forget"}
-
-	if !strings.HasPrefix(policy, "--") {
-		policy = "--" + policy
+	if policy.Empty() {
+		return nil, nil
 	}
+	return w.run(Exe, w.forgetArgs(policy))
+}
 
-	args = append(args, policy)
-	args = append(args, value)
+// forgetArgs returns the arguments of "restic forget" applying policy. Only
+// the snapshots having the tags of policy and, if set, the host of w are
+// considered, other clusters may share the repository.
+func (w *ResticWrapper) forgetArgs(policy RetentionPolicy) []interface{} {
+	args := []interface{}{"forget", "--json"}
+	args = append(args, policy.args()...)
 
-	if prune {
+	if w.hostname != "" {
+		args = append(args, "--host", w.hostname)
+	}
+
+	if policy.Prune {
 		args = append(args, "--prune")
 	}
 
-	if dryRun {
+	if policy.DryRun {
 		args = append(args, "--dry-run")
 	}
 
-	if len(args) > 1 {
-		args = w.appendCacheDirFlag(args)
-		args = w.appendCaCertFlag(args)
-
-		return w.run(Exe, args)
-	}
-	return nil, nil
+	args = w.appendCacheDirFlag(args)
+	return w.appendCaCertFlag(args)
 }
 
 func (w *ResticWrapper) Restore(path, host, snapshotID string) ([]byte, error) {
@@ -131,6 +137,43 @@ func (w *ResticWrapper) Restore(path, host, snapshotID string) ([]byte, error) {
 	return w.run(Exe, args)
 }
 
+// RestoreSnapshot restores every file of a snapshot into target. The files keep
+// their backed up absolute paths inside target.
+func (w *ResticWrapper) RestoreSnapshot(snapshotID, target string) ([]byte, error) {
+	args := []interface{}{"restore", snapshotID, "--target", target}
+	args = w.appendCacheDirFlag(args)
+	args = w.appendCaCertFlag(args)
+
+	return w.run(Exe, args)
+}
+
+// ListFiles returns the absolute paths of the files and directories stored in a snapshot
+func (w *ResticWrapper) ListFiles(snapshotID string) ([]string, error) {
+	args := w.appendCacheDirFlag([]interface{}{"ls", snapshotID, "--no-lock"})
+	args = w.appendCaCertFlag(args)
+
+	out, err := w.run(Exe, args)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		// the first line describes the snapshot
+		if strings.HasPrefix(line, "/") {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// Dump returns the content of a single file stored in a snapshot
+func (w *ResticWrapper) Dump(snapshotID, file string) ([]byte, error) {
+	args := w.appendCacheDirFlag([]interface{}{"dump", snapshotID, file, "--no-lock"})
+	args = w.appendCaCertFlag(args)
+
+	return w.run(Exe, args)
+}
+
 func (w *ResticWrapper) Check() ([]byte, error) {
 	log.Infoln("Checking integrity of repository")
 	args := w.appendCacheDirFlag([]interface{}{"check"})
@@ -142,7 +185,7 @@ func (w *ResticWrapper) Check() ([]byte, error) {
 func (w *ResticWrapper) Stats() ([]byte, error) {
 	log.Infoln("Reading repository status")
 	args := w.appendCacheDirFlag([]interface{}{"stats"})
-	args = append(args, "--mode=raw-data", "--quiet")
+	args = append(args, "--mode=raw-data", "--quiet", "--json")
 	args = w.appendCaCertFlag(args)
 
 	return w.run(Exe, args)
@@ -14,9 +14,11 @@ type ResticWrapper struct {
 }
 
 type BackupOptions struct {
-	ScratchDir      string
-	EnableCache     bool
-	Hostname        string
+	ScratchDir  string
+	EnableCache bool
+	Hostname    string
+	// ClusterName selects the snapshots of a cluster among the clusters sharing the repository
+	ClusterName     string
 	OutputDir       string
 	Provider        string
 	Bucket          string
@@ -27,8 +29,24 @@ type BackupOptions struct {
 }
 
 type RetentionPolicy struct {
+	// Policy and Value specify a single keep rule (i.e. keep-last and 5), they can be combined with the rules below
 	Policy string
 	Value  string
+
+	KeepLast    int
+	KeepHourly  int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	// KeepWithin is a restic duration (i.e. 30d, 1y6m)
+	KeepWithin string
+	KeepTags   []string
+	// GroupBy groups the snapshots by any of host, tags and paths before the rules are applied
+	GroupBy string
+	// Tags restricts the policy to the snapshots having all of these tags
+	Tags []string
+
 	Prune  bool
 	DryRun bool
 }
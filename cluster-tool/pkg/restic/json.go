@@ -0,0 +1,111 @@
+package restic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// backupSummary is the last message printed by "restic backup --json"
+type backupSummary struct {
+	MessageType         string  `json:"message_type"`
+	FilesNew            int     `json:"files_new"`
+	FilesChanged        int     `json:"files_changed"`
+	FilesUnmodified     int     `json:"files_unmodified"`
+	DataAdded           uint64  `json:"data_added"`
+	TotalFilesProcessed int     `json:"total_files_processed"`
+	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+	SnapshotID          string  `json:"snapshot_id"`
+}
+
+// forgetGroup is a snapshot group printed by "restic forget --json"
+type forgetGroup struct {
+	Tags   []string   `json:"tags"`
+	Host   string     `json:"host"`
+	Paths  []string   `json:"paths"`
+	Keep   []Snapshot `json:"keep"`
+	Remove []Snapshot `json:"remove"`
+	// Reasons is only printed by restic 0.9.6 and later
+	Reasons []keepReason `json:"reasons"`
+}
+
+// keepReason tells which rules of the policy matched a kept snapshot
+type keepReason struct {
+	Snapshot Snapshot `json:"snapshot"`
+	Matches  []string `json:"matches"`
+}
+
+// statsSummary is printed by "restic stats --json"
+type statsSummary struct {
+	TotalSize      uint64 `json:"total_size"`
+	TotalFileCount uint64 `json:"total_file_count"`
+}
+
+// decodeBackupSummary finds the summary message in the output of "restic backup --json".
+// It returns false if the output holds no summary, i.e. restic does not support --json for backup.
+func decodeBackupSummary(output []byte) (*backupSummary, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if !bytes.HasPrefix(line, []byte("{")) {
+			continue
+		}
+		summary := &backupSummary{}
+		if err := json.Unmarshal(line, summary); err == nil && summary.MessageType == "summary" {
+			return summary, true
+		}
+	}
+	return nil, false
+}
+
+// decodeForgetGroups decodes the output of "restic forget --json". The output of
+// prune may follow the groups. It returns false if the output is not JSON.
+func decodeForgetGroups(output []byte) ([]forgetGroup, bool) {
+	output = bytes.TrimSpace(output)
+	if !bytes.HasPrefix(output, []byte("[")) && !bytes.HasPrefix(output, []byte("null")) {
+		return nil, false
+	}
+	var groups []forgetGroup
+	if err := json.NewDecoder(bytes.NewReader(output)).Decode(&groups); err != nil {
+		return nil, false
+	}
+	return groups, true
+}
+
+// decodeStatsSummary decodes the output of "restic stats --json". It returns
+// false if the output is not JSON.
+func decodeStatsSummary(output []byte) (*statsSummary, bool) {
+	output = bytes.TrimSpace(output)
+	if !bytes.HasPrefix(output, []byte("{")) {
+		return nil, false
+	}
+	summary := &statsSummary{}
+	if err := json.Unmarshal(output, summary); err != nil {
+		return nil, false
+	}
+	return summary, true
+}
+
+// formatBytes formats size the way restic prints sizes in human readable output
+func formatBytes(size uint64) string {
+	switch {
+	case size > 1<<40:
+		return fmt.Sprintf("%.3f TiB", float64(size)/(1<<40))
+	case size > 1<<30:
+		return fmt.Sprintf("%.3f GiB", float64(size)/(1<<30))
+	case size > 1<<20:
+		return fmt.Sprintf("%.3f MiB", float64(size)/(1<<20))
+	case size > 1<<10:
+		return fmt.Sprintf("%.3f KiB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// formatDuration formats seconds the way ProcessingTime is reported
+func formatDuration(seconds float64) string {
+	s := int(seconds)
+	return fmt.Sprintf("%dm%ds", s/60, s%60)
+}
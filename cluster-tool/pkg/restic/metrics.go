@@ -26,6 +26,8 @@ type BackupMetrics struct {
 	DataProcessingTime prometheus.Gauge
 	// FileMetrics shows information of backup files
 	FileMetrics *FileMetrics
+	// FailedResources shows number of group/versions and resources skipped by a best-effort backup
+	FailedResources prometheus.Gauge
 }
 type FileMetrics struct {
 	// TotalFiles shows total number of files that has been backed up
@@ -95,6 +97,15 @@ func NewMetrics(labels prometheus.Labels) *Metrics {
 					ConstLabels: labels,
 				},
 			),
+			FailedResources: prometheus.NewGauge(
+				prometheus.GaugeOpts{
+					Namespace:   "restic",
+					Subsystem:   "backup",
+					Name:        "failed_resources",
+					Help:        "Number of group/versions and resources that were skipped by a best-effort backup",
+					ConstLabels: labels,
+				},
+			),
 			FileMetrics: &FileMetrics{
 				TotalFiles: prometheus.NewGauge(
 					prometheus.GaugeOpts{
@@ -199,6 +210,7 @@ func (metrics *Metrics) SetValues(backupOutput *BackupOutput) error {
 	metrics.BackupMetrics.FileMetrics.NewFiles.Set(float64(*backupOutput.BackupStats.FileStats.NewFiles))
 	metrics.BackupMetrics.FileMetrics.ModifiedFiles.Set(float64(*backupOutput.BackupStats.FileStats.ModifiedFiles))
 	metrics.BackupMetrics.FileMetrics.UnmodifiedFiles.Set(float64(*backupOutput.BackupStats.FileStats.UnmodifiedFiles))
+	metrics.BackupMetrics.FailedResources.Set(float64(len(backupOutput.BackupStats.FailedResources)))
 
 	// set repository metrics values
 	if *backupOutput.RepositoryStats.Integrity {
@@ -249,6 +261,7 @@ func (metricOpt *MetricsOptions) HandleMetrics(backupOutput *BackupOutput, backu
 		metrics.BackupMetrics.DataUploaded,
 		metrics.BackupMetrics.DataProcessingTime,
 		metrics.BackupMetrics.BackupSuccess,
+		metrics.BackupMetrics.FailedResources,
 		// register repository metrics
 		metrics.RepositoryMetrics.RepoIntegrity,
 		metrics.RepositoryMetrics.RepoSize,
@@ -19,6 +19,8 @@ type BackupOutput struct {
 	BackupStats BackupStats `json:"backup,omitempty"`
 	// RepositoryStats shows statistics of repository after last backup
 	RepositoryStats RepositoryStats `json:"repository,omitempty"`
+	// RetentionPlan shows the snapshots kept and removed by the retention policy on last backup session
+	RetentionPlan *RetentionPlan `json:"retention,omitempty"`
 }
 
 type BackupStats struct {
@@ -32,6 +34,8 @@ type BackupStats struct {
 	ProcessingTime string `json:"processingTime,omitempty"`
 	// FileStats shows statistics of files of backup session
 	FileStats FileStats `json:"fileStats,omitempty"`
+	// FailedResources shows the group/versions and resources that were skipped by a best-effort backup
+	FailedResources []FailedResource `json:"failedResources,omitempty"`
 }
 type RepositoryStats struct {
 	// Integrity shows result of repository integrity check after last backup
@@ -55,6 +59,17 @@ type FileStats struct {
 	UnmodifiedFiles *int `json:"unmodifiedFiles,omitempty"`
 }
 
+type FailedResource struct {
+	// GroupVersion of the failed group/version or resource
+	GroupVersion string `json:"groupVersion"`
+	// Resource indicates the failed resource (empty if the whole group/version failed)
+	Resource string `json:"resource,omitempty"`
+	// Namespace indicates the namespace the resource failed to be listed in
+	Namespace string `json:"namespace,omitempty"`
+	// Error shows the cause of the failure
+	Error string `json:"error"`
+}
+
 // WriteOutput write output of backup process into output.json file in the directory
 // specified by outputDir parameter
 func WriteOutput(out *BackupOutput, outputDir string) error {
@@ -73,8 +88,24 @@ func WriteOutput(out *BackupOutput, outputDir string) error {
 }
 
 // ExtractBackupInfo extract information from output of "restic backup" command and
-// save valuable information into backupOutput
+// save valuable information into backupOutput. The summary printed with --json is
+// used when available, otherwise the human readable output is parsed.
 func (backupOutput *BackupOutput) ExtractBackupInfo(output []byte) error {
+	if summary, ok := decodeBackupSummary(output); ok {
+		backupOutput.BackupStats.FileStats.TotalFiles = types.IntP(summary.TotalFilesProcessed)
+		backupOutput.BackupStats.FileStats.NewFiles = types.IntP(summary.FilesNew)
+		backupOutput.BackupStats.FileStats.ModifiedFiles = types.IntP(summary.FilesChanged)
+		backupOutput.BackupStats.FileStats.UnmodifiedFiles = types.IntP(summary.FilesUnmodified)
+		backupOutput.BackupStats.Size = formatBytes(summary.TotalBytesProcessed)
+		backupOutput.BackupStats.Uploaded = formatBytes(summary.DataAdded)
+		backupOutput.BackupStats.ProcessingTime = formatDuration(summary.TotalDuration)
+		backupOutput.BackupStats.Snapshot = ShortID(summary.SnapshotID)
+		return nil
+	}
+	return backupOutput.extractBackupInfoFromText(output)
+}
+
+func (backupOutput *BackupOutput) extractBackupInfoFromText(output []byte) error {
 	scanner := bufio.NewScanner(bytes.NewReader(output))
 	var line string
 	for scanner.Scan() {
@@ -151,9 +182,24 @@ func (backupOutput *BackupOutput) ExtractCheckInfo(out []byte) {
 	backupOutput.RepositoryStats.Integrity = types.BoolP(false)
 }
 
-// ExtractCleanupInfo extract information from output of "restic forget" command and
-// save valuable information into backupOutput
-func (backupOutput *BackupOutput) ExtractCleanupInfo(out []byte) error {
+// ExtractCleanupInfo extract information from output of "restic forget" command run with
+// policy and save valuable information into backupOutput. The output of --json is used
+// when available, otherwise the human readable output is parsed.
+func (backupOutput *BackupOutput) ExtractCleanupInfo(out []byte, policy RetentionPolicy) error {
+	if _, ok := decodeForgetGroups(out); ok {
+		plan, err := ExtractRetentionPlan(out, policy)
+		if err != nil {
+			return err
+		}
+		backupOutput.RepositoryStats.SnapshotRemovedOnLastCleanup = len(plan.Remove)
+		backupOutput.RepositoryStats.SnapshotCount = len(plan.Keep)
+		backupOutput.RetentionPlan = plan
+		return nil
+	}
+	return backupOutput.extractCleanupInfoFromText(out)
+}
+
+func (backupOutput *BackupOutput) extractCleanupInfoFromText(out []byte) error {
 	scanner := bufio.NewScanner(bytes.NewReader(out))
 	var line string
 	snapshotCount := 0
@@ -192,8 +238,17 @@ func (backupOutput *BackupOutput) ExtractCleanupInfo(out []byte) error {
 }
 
 // ExtractStatsInfo extract information from output of "restic stats" command and
-// save valuable information into backupOutput
+// save valuable information into backupOutput. The output of --json is used when
+// available, otherwise the human readable output is parsed.
 func (backupOutput *BackupOutput) ExtractStatsInfo(out []byte) error {
+	if summary, ok := decodeStatsSummary(out); ok {
+		backupOutput.RepositoryStats.Size = formatBytes(summary.TotalSize)
+		return nil
+	}
+	return backupOutput.extractStatsInfoFromText(out)
+}
+
+func (backupOutput *BackupOutput) extractStatsInfoFromText(out []byte) error {
 	scanner := bufio.NewScanner(bytes.NewReader(out))
 	var line string
 	for scanner.Scan() {
@@ -0,0 +1,176 @@
+package restic
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The outputs in testdata/restic-<version> follow what the restic version
+// prints: 0.9.4 has no --json for backup and stats and reports no reasons in
+// forget --json, 0.9.6 added them and 0.12.1 adds fields that are ignored.
+
+func readOutput(t *testing.T, version, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "restic-"+version, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func intP(i int) *int {
+	return &i
+}
+
+func TestExtractBackupInfo(t *testing.T) {
+	want := BackupStats{
+		Snapshot:       "8c1a9d2e",
+		Size:           "4.772 MiB",
+		Uploaded:       "1.091 MiB",
+		ProcessingTime: "0m2s",
+		FileStats: FileStats{
+			TotalFiles:      intP(1204),
+			NewFiles:        intP(12),
+			ModifiedFiles:   intP(35),
+			UnmodifiedFiles: intP(1157),
+		},
+	}
+	cases := []struct {
+		version string
+		file    string
+	}{
+		{"0.9.4", "backup.txt"},
+		{"0.9.6", "backup.json"},
+		{"0.12.1", "backup.json"},
+	}
+	for _, c := range cases {
+		t.Run(c.version+"/"+c.file, func(t *testing.T) {
+			out := &BackupOutput{}
+			if err := out.ExtractBackupInfo(readOutput(t, c.version, c.file)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out.BackupStats, want) {
+				t.Errorf("got %+v, want %+v", out.BackupStats, want)
+			}
+		})
+	}
+}
+
+func TestExtractCleanupInfo(t *testing.T) {
+	cases := []struct {
+		version     string
+		file        string
+		kept        int
+		removed     int
+		wantPlan    bool
+		keepReasons [][]string
+		keepIDs     []string
+	}{
+		{version: "0.9.4", file: "forget.txt", kept: 2, removed: 1},
+		{
+			version:     "0.9.4",
+			file:        "forget.json",
+			kept:        2,
+			removed:     1,
+			wantPlan:    true,
+			keepIDs:     []string{"", ""},
+			keepReasons: [][]string{{ReasonPolicyMatched}, {ReasonPolicyMatched}},
+		},
+		{version: "0.9.6", file: "forget.txt", kept: 2, removed: 1},
+		{
+			version:     "0.9.6",
+			file:        "forget.json",
+			kept:        2,
+			removed:     1,
+			wantPlan:    true,
+			keepIDs:     []string{"4a2f3b1c", "8c1a9d2e"},
+			keepReasons: [][]string{{"last snapshot"}, {"last snapshot"}},
+		},
+		{
+			version:     "0.12.1",
+			file:        "forget.json",
+			kept:        2,
+			removed:     1,
+			wantPlan:    true,
+			keepIDs:     []string{"4a2f3b1c", "8c1a9d2e"},
+			keepReasons: [][]string{{"daily snapshot", "last snapshot"}, {"daily snapshot", "last snapshot"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.version+"/"+c.file, func(t *testing.T) {
+			out := &BackupOutput{}
+			if err := out.ExtractCleanupInfo(readOutput(t, c.version, c.file), RetentionPolicy{KeepLast: 2}); err != nil {
+				t.Fatal(err)
+			}
+			if out.RepositoryStats.SnapshotCount != c.kept || out.RepositoryStats.SnapshotRemovedOnLastCleanup != c.removed {
+				t.Errorf("kept %d and removed %d snapshots, want %d and %d",
+					out.RepositoryStats.SnapshotCount, out.RepositoryStats.SnapshotRemovedOnLastCleanup, c.kept, c.removed)
+			}
+			if !c.wantPlan {
+				if out.RetentionPlan != nil {
+					t.Errorf("text output has a retention plan: %+v", out.RetentionPlan)
+				}
+				return
+			}
+			if out.RetentionPlan == nil {
+				t.Fatal("no retention plan")
+			}
+			var ids []string
+			var reasons [][]string
+			for _, d := range out.RetentionPlan.Keep {
+				ids = append(ids, d.ShortID())
+				reasons = append(reasons, d.Reasons)
+			}
+			if !reflect.DeepEqual(ids, c.keepIDs) || !reflect.DeepEqual(reasons, c.keepReasons) {
+				t.Errorf("kept %v for %v, want %v for %v", ids, reasons, c.keepIDs, c.keepReasons)
+			}
+			for _, d := range out.RetentionPlan.Remove {
+				if !reflect.DeepEqual(d.Reasons, []string{ReasonNoRuleMatched}) {
+					t.Errorf("removed %s for %v", d.ShortID(), d.Reasons)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractStatsInfo(t *testing.T) {
+	cases := []struct {
+		version string
+		file    string
+	}{
+		{"0.9.4", "stats.txt"},
+		{"0.9.6", "stats.json"},
+		{"0.12.1", "stats.json"},
+	}
+	for _, c := range cases {
+		t.Run(c.version+"/"+c.file, func(t *testing.T) {
+			out := &BackupOutput{}
+			if err := out.ExtractStatsInfo(readOutput(t, c.version, c.file)); err != nil {
+				t.Fatal(err)
+			}
+			if out.RepositoryStats.Size != "38.116 MiB" {
+				t.Errorf("size = %q, want %q", out.RepositoryStats.Size, "38.116 MiB")
+			}
+		})
+	}
+}
+
+func TestConvertToMinutesSeconds(t *testing.T) {
+	cases := []struct {
+		in      string
+		m, s    int
+		wantErr bool
+	}{
+		{in: "0:02", m: 0, s: 2},
+		{in: "3:59", m: 3, s: 59},
+		{in: "1:02:03", m: 62, s: 3},
+		{in: "2s", wantErr: true},
+	}
+	for _, c := range cases {
+		m, s, err := convertToMinutesSeconds(c.in)
+		if (err != nil) != c.wantErr || m != c.m || s != c.s {
+			t.Errorf("convertToMinutesSeconds(%q) = %d, %d, %v", c.in, m, s, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package restic
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ReasonSelectedByID is the reason of snapshots removed explicitly by ID
+	ReasonSelectedByID = "selected by ID"
+	// ReasonNoRuleMatched is the reason of snapshots removed by a retention policy
+	ReasonNoRuleMatched = "no keep rule matched"
+	// ReasonPolicyMatched is the reason of kept snapshots if restic does not report the matched rules
+	ReasonPolicyMatched = "retention policy matched"
+)
+
+var (
+	keepPolicies   = []string{"keep-last", "keep-hourly", "keep-daily", "keep-weekly", "keep-monthly", "keep-yearly", "keep-within", "keep-tag"}
+	groupByFields  = []string{"host", "tags", "paths"}
+	durationFormat = regexp.MustCompile(`^([0-9]+[ymdh])+$`)
+)
+
+// Empty returns true if the policy has no keep rule
+func (p RetentionPolicy) Empty() bool {
+	return p.Policy == "" && p.KeepLast == 0 && p.KeepHourly == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 &&
+		p.KeepMonthly == 0 && p.KeepYearly == 0 && p.KeepWithin == "" && len(p.KeepTags) == 0
+}
+
+func (p RetentionPolicy) Validate() error {
+	if p.Empty() {
+		return errors.New("retention policy has no keep rule")
+	}
+	if p.Policy != "" {
+		policy := strings.TrimPrefix(p.Policy, "--")
+		if !containsString(keepPolicies, policy) {
+			return fmt.Errorf("unknown retention policy %q, must be one of %s", p.Policy, strings.Join(keepPolicies, ", "))
+		}
+		if p.Value == "" {
+			return fmt.Errorf("retention policy %s has no value", p.Policy)
+		}
+	}
+	for _, tag := range p.Tags {
+		if tag == "" || strings.ContainsRune(tag, ',') {
+			return fmt.Errorf("invalid tag %q, tags must not be empty or hold commas", tag)
+		}
+	}
+	for _, n := range []int{p.KeepLast, p.KeepHourly, p.KeepDaily, p.KeepWeekly, p.KeepMonthly, p.KeepYearly} {
+		if n < 0 {
+			return errors.New("number of snapshots to keep can not be negative")
+		}
+	}
+	if p.KeepWithin != "" && !durationFormat.MatchString(p.KeepWithin) {
+		return fmt.Errorf("invalid keep-within duration %q, use years, months, days and hours (i.e. 30d, 1y6m)", p.KeepWithin)
+	}
+	if p.GroupBy != "" {
+		for _, field := range strings.Split(p.GroupBy, ",") {
+			if !containsString(groupByFields, field) {
+				return fmt.Errorf("unknown group-by field %q, must be any of %s", field, strings.Join(groupByFields, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// Rules returns the keep rules, grouping and tags of the policy (i.e. keep-last=3, group-by=tags,paths)
+func (p RetentionPolicy) Rules() []string {
+	args := p.args()
+	rules := make([]string, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		rules = append(rules, fmt.Sprintf("%s=%s", strings.TrimPrefix(args[i].(string), "--"), args[i+1]))
+	}
+	return rules
+}
+
+// args returns the keep rules as arguments of "restic forget"
+func (p RetentionPolicy) args() []interface{} {
+	var args []interface{}
+	if p.Policy != "" {
+		args = append(args, "--"+strings.TrimPrefix(p.Policy, "--"), p.Value)
+	}
+	counts := []struct {
+		flag string
+		n    int
+	}{
+		{"--keep-last", p.KeepLast},
+		{"--keep-hourly", p.KeepHourly},
+		{"--keep-daily", p.KeepDaily},
+		{"--keep-weekly", p.KeepWeekly},
+		{"--keep-monthly", p.KeepMonthly},
+		{"--keep-yearly", p.KeepYearly},
+	}
+	for _, c := range counts {
+		if c.n > 0 {
+			args = append(args, c.flag, strconv.Itoa(c.n))
+		}
+	}
+	if p.KeepWithin != "" {
+		args = append(args, "--keep-within", p.KeepWithin)
+	}
+	for _, tag := range p.KeepTags {
+		args = append(args, "--keep-tag", tag)
+	}
+	if p.GroupBy != "" {
+		args = append(args, "--group-by", p.GroupBy)
+	}
+	if len(p.Tags) > 0 {
+		args = append(args, "--tag", strings.Join(p.Tags, ","))
+	}
+	return args
+}
+
+// RetentionPlan lists the snapshots kept and removed by "restic forget"
+type RetentionPlan struct {
+	// Rules shows the applied retention policy
+	Rules []string `json:"rules,omitempty"`
+	// DryRun is true if nothing has actually been removed
+	DryRun bool `json:"dryRun,omitempty"`
+	// Keep lists the snapshots kept, along with the matched keep rules
+	Keep []SnapshotDecision `json:"keep"`
+	// Remove lists the snapshots removed
+	Remove []SnapshotDecision `json:"remove"`
+}
+
+type SnapshotDecision struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Paths    []string  `json:"paths,omitempty"`
+	// Reasons tells why the snapshot has been kept or removed
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func newSnapshotDecision(s Snapshot, reasons []string) SnapshotDecision {
+	return SnapshotDecision{
+		ID:       s.ID,
+		Time:     s.Time,
+		Hostname: s.Hostname,
+		Tags:     s.Tags,
+		Paths:    s.Paths,
+		Reasons:  reasons,
+	}
+}
+
+// ShortID returns the abbreviated snapshot ID, as printed by restic
+func (d SnapshotDecision) ShortID() string {
+	return ShortID(d.ID)
+}
+
+// ExtractRetentionPlan builds the RetentionPlan from the output of "restic forget --json"
+// run with policy
+func ExtractRetentionPlan(out []byte, policy RetentionPolicy) (*RetentionPlan, error) {
+	groups, ok := decodeForgetGroups(out)
+	if !ok {
+		return nil, errors.New("failed to decode output of restic forget, restic 0.9.0 or later is required")
+	}
+
+	plan := &RetentionPlan{
+		Rules:  policy.Rules(),
+		DryRun: policy.DryRun,
+		Keep:   make([]SnapshotDecision, 0),
+		Remove: make([]SnapshotDecision, 0),
+	}
+	for _, g := range groups {
+		matches := make(map[string][]string)
+		for _, r := range g.Reasons {
+			matches[r.Snapshot.ID] = r.Matches
+		}
+		for _, s := range g.Keep {
+			reasons, ok := matches[s.ID]
+			if !ok {
+				reasons = []string{ReasonPolicyMatched}
+			}
+			plan.Keep = append(plan.Keep, newSnapshotDecision(s, reasons))
+		}
+		for _, s := range g.Remove {
+			plan.Remove = append(plan.Remove, newSnapshotDecision(s, []string{ReasonNoRuleMatched}))
+		}
+	}
+	plan.sort()
+	return plan, nil
+}
+
+// DeletionPlan builds the RetentionPlan of explicitly removed snapshots
+func DeletionPlan(snapshots []Snapshot, dryRun bool) *RetentionPlan {
+	plan := &RetentionPlan{
+		DryRun: dryRun,
+		Keep:   make([]SnapshotDecision, 0),
+		Remove: make([]SnapshotDecision, 0, len(snapshots)),
+	}
+	for _, s := range snapshots {
+		plan.Remove = append(plan.Remove, newSnapshotDecision(s, []string{ReasonSelectedByID}))
+	}
+	plan.sort()
+	return plan
+}
+
+func (p *RetentionPlan) sort() {
+	for _, list := range [][]SnapshotDecision{p.Keep, p.Remove} {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Time.Before(list[j].Time)
+		})
+	}
+}
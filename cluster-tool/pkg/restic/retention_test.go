@@ -0,0 +1,114 @@
+package restic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  RetentionPolicy
+		wantErr string
+	}{
+		{"no keep rule", RetentionPolicy{GroupBy: "tags"}, "no keep rule"},
+		{"single policy", RetentionPolicy{Policy: "keep-last", Value: "5"}, ""},
+		{"single policy with dashes", RetentionPolicy{Policy: "--keep-daily", Value: "7"}, ""},
+		{"unknown policy", RetentionPolicy{Policy: "keep-forever", Value: "1"}, "unknown retention policy"},
+		{"policy without value", RetentionPolicy{Policy: "keep-last"}, "has no value"},
+		{"combined rules", RetentionPolicy{KeepLast: 3, KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 12, KeepWithin: "1y6m", KeepTags: []string{"release"}}, ""},
+		{"negative count", RetentionPolicy{KeepLast: 3, KeepDaily: -1}, "can not be negative"},
+		{"invalid duration", RetentionPolicy{KeepWithin: "30 days"}, "invalid keep-within duration"},
+		{"group by", RetentionPolicy{KeepLast: 3, GroupBy: "host,tags,paths"}, ""},
+		{"unknown group by field", RetentionPolicy{KeepLast: 3, GroupBy: "tags,cluster"}, `unknown group-by field "cluster"`},
+		{"tags", RetentionPolicy{KeepLast: 3, Tags: []string{"cluster:prod"}}, ""},
+		{"empty tag", RetentionPolicy{KeepLast: 3, Tags: []string{""}}, "invalid tag"},
+		{"tag with comma", RetentionPolicy{KeepLast: 3, Tags: []string{"cluster:a,b"}}, "invalid tag"},
+	}
+	for _, c := range cases {
+		err := c.policy.Validate()
+		if c.wantErr == "" && err != nil || c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("%s: Validate() = %v, want an error containing %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestRetentionPolicyArgs(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{
+			name:   "single policy",
+			policy: RetentionPolicy{Policy: "keep-last", Value: "5"},
+			want:   []string{"--keep-last", "5"},
+		},
+		{
+			name: "combined rules",
+			policy: RetentionPolicy{
+				Policy: "--keep-tag", Value: "release",
+				KeepLast: 3, KeepDaily: 7, KeepYearly: 1, KeepWithin: "30d", KeepTags: []string{"manual", "weekly"},
+				GroupBy: "tags,paths", Tags: []string{"cluster:prod", "partial"},
+			},
+			want: []string{
+				"--keep-tag", "release",
+				"--keep-last", "3", "--keep-daily", "7", "--keep-yearly", "1",
+				"--keep-within", "30d", "--keep-tag", "manual", "--keep-tag", "weekly",
+				"--group-by", "tags,paths", "--tag", "cluster:prod,partial",
+			},
+		},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, arg := range c.policy.args() {
+			got = append(got, arg.(string))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: args() = %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	policy := RetentionPolicy{KeepLast: 3, GroupBy: "tags,paths", Tags: []string{"cluster:prod"}}
+	if got, want := policy.Rules(), []string{"keep-last=3", "group-by=tags,paths", "tag=cluster:prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rules() = %q, want %q", got, want)
+	}
+}
+
+func TestForgetArgs(t *testing.T) {
+	policy := RetentionPolicy{KeepLast: 3, GroupBy: "tags,paths", Tags: []string{"cluster:prod"}}
+	cases := []struct {
+		name     string
+		hostname string
+		policy   RetentionPolicy
+		want     string
+	}{
+		{
+			name:   "policy of a cluster",
+			policy: policy,
+			want:   "forget --json --keep-last 3 --group-by tags,paths --tag cluster:prod --no-cache",
+		},
+		{
+			name:     "policy of a host",
+			hostname: "backup-host",
+			policy:   policy,
+			want:     "forget --json --keep-last 3 --group-by tags,paths --tag cluster:prod --host backup-host --no-cache",
+		},
+		{
+			name:   "prune and dry run",
+			policy: RetentionPolicy{KeepDaily: 7, Prune: true, DryRun: true},
+			want:   "forget --json --keep-daily 7 --prune --dry-run --no-cache",
+		},
+	}
+	for _, c := range cases {
+		w := &ResticWrapper{hostname: c.hostname}
+		var got []string
+		for _, arg := range w.forgetArgs(c.policy) {
+			got = append(got, arg.(string))
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("%s: forgetArgs() = %s, want %s", c.name, strings.Join(got, " "), c.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package restic
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotFilter selects snapshots by host, tag and time. Empty fields match every snapshot.
+type SnapshotFilter struct {
+	Hosts []string
+	// Tags matches snapshots having all of the tags
+	Tags  []string
+	Since time.Time
+	Until time.Time
+}
+
+// ShortID returns the abbreviated snapshot ID, as printed by restic
+func (s Snapshot) ShortID() string {
+	return ShortID(s.ID)
+}
+
+// Matches returns true if the snapshot is selected by the filter
+func (f SnapshotFilter) Matches(s Snapshot) bool {
+	if len(f.Hosts) > 0 && !containsString(f.Hosts, s.Hostname) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !containsString(s.Tags, tag) {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && s.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && s.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// FilterSnapshots returns the snapshots selected by filter, oldest first
+func FilterSnapshots(snapshots []Snapshot, filter SnapshotFilter) []Snapshot {
+	result := make([]Snapshot, 0, len(snapshots))
+	for _, s := range snapshots {
+		if filter.Matches(s) {
+			result = append(result, s)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
+
+// ResolveSnapshot finds the snapshot ref refers to in snapshots sorted oldest first.
+// ref is either a snapshot ID, a unique prefix of it, "latest" or "latest~n" for
+// the n-th snapshot before the latest one.
+func ResolveSnapshot(snapshots []Snapshot, ref string) (Snapshot, error) {
+	if ref == "latest" || strings.HasPrefix(ref, "latest~") {
+		n := 0
+		if ref != "latest" {
+			var err error
+			n, err = strconv.Atoi(strings.TrimPrefix(ref, "latest~"))
+			if err != nil || n < 0 {
+				return Snapshot{}, errors.Errorf("invalid snapshot %q", ref)
+			}
+		}
+		if n >= len(snapshots) {
+			return Snapshot{}, errors.Errorf("snapshot %s not found, there are %d snapshots", ref, len(snapshots))
+		}
+		return snapshots[len(snapshots)-1-n], nil
+	}
+
+	var found []Snapshot
+	for _, s := range snapshots {
+		if strings.HasPrefix(s.ID, ref) {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Snapshot{}, errors.Errorf("snapshot %s not found", ref)
+	case 1:
+		return found[0], nil
+	}
+	return Snapshot{}, errors.Errorf("snapshot ID %s is ambiguous", ref)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
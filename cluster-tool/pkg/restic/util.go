@@ -8,28 +8,25 @@ import (
 	"github.com/pkg/errors"
 )
 
+// convertToMinutesSeconds parses the duration restic prints after "processed",
+// formatted as m:ss or h:mm:ss
 func convertToMinutesSeconds(time string) (int, int, error) {
 	parts := strings.Split(time, ":")
-	if len(parts) != 2 {
+	if len(parts) != 2 && len(parts) != 3 {
 		return 0, 0, fmt.Errorf("failed to convert minutes")
 	}
-	minutes, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return 0, 0, err
-	}
-
-	fraction, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return 0, 0, err
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, 0, err
+		}
+		values[i] = v
 	}
-	seconds := int((fraction * 60) / 100)
-	if seconds >= 60 {
-		m := int(seconds / 60)
-		minutes = minutes + m
-		seconds = seconds - m*60
+	if len(values) == 3 {
+		return values[0]*60 + values[1], values[2], nil
 	}
-
-	return minutes, seconds, nil
+	return values[0], values[1], nil
 }
 
 func separators(r rune) bool {
@@ -67,6 +64,12 @@ func convertSizeToBytes(dataSize string) (float64, error) {
 			return 0, err
 		}
 		return size * 1024 * 1024 * 1024, nil
+	case "TiB", "TB":
+		size, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return 0, err
+		}
+		return size * 1024 * 1024 * 1024 * 1024, nil
 	}
 	return 0, errors.New("unknown unit for data size")
 }
@@ -80,3 +83,11 @@ func convertTimeToSeconds(processingTime string) (int, error) {
 
 	return minutes*60 + seconds, nil
 }
+
+// ShortID returns the short form of a snapshot ID, as printed by restic
+func ShortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
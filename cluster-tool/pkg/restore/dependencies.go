@@ -0,0 +1,143 @@
+package restore
+
+import (
+	"encoding/json"
+	"sort"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Dependency is an object in the namespace of another object, that the other
+// object needs to run
+type Dependency struct {
+	// Kind is one of ConfigMap, Secret, ServiceAccount, PersistentVolumeClaim or Service
+	Kind string
+	Name string
+}
+
+// Dependencies returns the objects referenced by the pod template of obj and the
+// labels of its pods. Objects without pod template have no dependencies.
+func Dependencies(obj *unstructured.Unstructured) ([]Dependency, map[string]string, error) {
+	template, ok := podTemplate(obj)
+	if !ok {
+		return nil, nil, nil
+	}
+	data, err := json.Marshal(template)
+	if err != nil {
+		return nil, nil, err
+	}
+	pod := &core.PodTemplateSpec{}
+	if err := json.Unmarshal(data, pod); err != nil {
+		return nil, nil, err
+	}
+
+	deps := make(map[Dependency]bool)
+	add := func(kind, name string) {
+		if name != "" {
+			deps[Dependency{Kind: kind, Name: name}] = true
+		}
+	}
+
+	spec := pod.Spec
+	sa := spec.ServiceAccountName
+	if sa == "" {
+		sa = spec.DeprecatedServiceAccount
+	}
+	if sa != "default" {
+		add("ServiceAccount", sa)
+	}
+	for _, s := range spec.ImagePullSecrets {
+		add("Secret", s.Name)
+	}
+	for _, v := range spec.Volumes {
+		if v.ConfigMap != nil {
+			add("ConfigMap", v.ConfigMap.Name)
+		}
+		if v.Secret != nil {
+			add("Secret", v.Secret.SecretName)
+		}
+		if v.PersistentVolumeClaim != nil {
+			add("PersistentVolumeClaim", v.PersistentVolumeClaim.ClaimName)
+		}
+		if v.Projected != nil {
+			for _, s := range v.Projected.Sources {
+				if s.ConfigMap != nil {
+					add("ConfigMap", s.ConfigMap.Name)
+				}
+				if s.Secret != nil {
+					add("Secret", s.Secret.Name)
+				}
+			}
+		}
+	}
+	for _, c := range append(spec.InitContainers, spec.Containers...) {
+		for _, e := range c.EnvFrom {
+			if e.ConfigMapRef != nil {
+				add("ConfigMap", e.ConfigMapRef.Name)
+			}
+			if e.SecretRef != nil {
+				add("Secret", e.SecretRef.Name)
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom == nil {
+				continue
+			}
+			if e.ValueFrom.ConfigMapKeyRef != nil {
+				add("ConfigMap", e.ValueFrom.ConfigMapKeyRef.Name)
+			}
+			if e.ValueFrom.SecretKeyRef != nil {
+				add("Secret", e.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+	// the governing Service of a StatefulSet
+	if name, ok, _ := unstructured.NestedString(obj.Object, "spec", "serviceName"); ok {
+		add("Service", name)
+	}
+
+	result := make([]Dependency, 0, len(deps))
+	for d := range deps {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, pod.Labels, nil
+}
+
+// podTemplate returns the pod template of workloads, the pod template of the
+// jobs of CronJobs or a Pod itself. The template is not copied, changes to it
+// modify obj.
+func podTemplate(obj *unstructured.Unstructured) (map[string]interface{}, bool) {
+	if obj.GetKind() == "Pod" {
+		return obj.Object, true
+	}
+	if v, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "jobTemplate", "spec", "template"); ok {
+		if template, ok := v.(map[string]interface{}); ok {
+			return template, true
+		}
+	}
+	if v, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "template"); ok {
+		if template, ok := v.(map[string]interface{}); ok {
+			if _, ok := template["spec"]; ok {
+				return template, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ServiceSelects reports whether svc selects the pods labelled with podLabels
+func ServiceSelects(svc *unstructured.Unstructured, podLabels map[string]string) bool {
+	selector, ok, _ := unstructured.NestedStringMap(svc.Object, "spec", "selector")
+	if !ok || len(selector) == 0 {
+		return false
+	}
+	return labels.SelectorFromSet(selector).Matches(labels.Set(podLabels))
+}
@@ -0,0 +1,236 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	cases := []struct {
+		name   string
+		obj    string
+		want   []Dependency
+		labels map[string]string
+	}{
+		{
+			name: "deployment",
+			obj: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {namespace: payments, name: api}
+spec:
+  template:
+    metadata:
+      labels: {app: api}
+    spec:
+      serviceAccountName: api
+      imagePullSecrets:
+      - name: registry
+      initContainers:
+      - name: migrate
+        envFrom:
+        - configMapRef: {name: migrations}
+      containers:
+      - name: api
+        envFrom:
+        - configMapRef: {name: api-config}
+        - secretRef: {name: api-env}
+        env:
+        - {name: PLAIN, value: "1"}
+        - name: DB_PASSWORD
+          valueFrom: {secretKeyRef: {name: db, key: password}}
+        - name: MODE
+          valueFrom: {configMapKeyRef: {name: api-config, key: mode}}
+        - name: POD_NAME
+          valueFrom: {fieldRef: {fieldPath: metadata.name}}
+      volumes:
+      - name: config
+        configMap: {name: api-files}
+      - name: tls
+        secret: {secretName: api-tls}
+      - name: data
+        persistentVolumeClaim: {claimName: api-data}
+      - name: bundle
+        projected:
+          sources:
+          - configMap: {name: ca-bundle}
+          - secret: {name: client-cert}
+          - serviceAccountToken: {path: token}
+      - name: scratch
+        emptyDir: {}
+`,
+			want: []Dependency{
+				{Kind: "ConfigMap", Name: "api-config"},
+				{Kind: "ConfigMap", Name: "api-files"},
+				{Kind: "ConfigMap", Name: "ca-bundle"},
+				{Kind: "ConfigMap", Name: "migrations"},
+				{Kind: "PersistentVolumeClaim", Name: "api-data"},
+				{Kind: "Secret", Name: "api-env"},
+				{Kind: "Secret", Name: "api-tls"},
+				{Kind: "Secret", Name: "client-cert"},
+				{Kind: "Secret", Name: "db"},
+				{Kind: "Secret", Name: "registry"},
+				{Kind: "ServiceAccount", Name: "api"},
+			},
+			labels: map[string]string{"app": "api"},
+		},
+		{
+			name: "statefulset",
+			obj: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {namespace: payments, name: db}
+spec:
+  serviceName: db-headless
+  template:
+    metadata:
+      labels: {app: db}
+    spec:
+      serviceAccountName: default
+      containers:
+      - name: db
+`,
+			want:   []Dependency{{Kind: "Service", Name: "db-headless"}},
+			labels: map[string]string{"app": "db"},
+		},
+		{
+			name: "deprecated service account of a pod",
+			obj: `
+apiVersion: v1
+kind: Pod
+metadata:
+  namespace: payments
+  name: debug
+  labels: {app: debug}
+spec:
+  serviceAccount: debugger
+  containers:
+  - name: shell
+`,
+			want:   []Dependency{{Kind: "ServiceAccount", Name: "debugger"}},
+			labels: map[string]string{"app": "debug"},
+		},
+		{
+			name: "cronjob",
+			obj: `
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata: {namespace: payments, name: report}
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: report
+            envFrom:
+            - secretRef: {name: smtp}
+`,
+			want: []Dependency{{Kind: "Secret", Name: "smtp"}},
+		},
+		{
+			name: "no pod template",
+			obj: `
+apiVersion: v1
+kind: ConfigMap
+metadata: {namespace: payments, name: api-config}
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, labels, err := Dependencies(decodeYAML(t, c.obj))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deps) != 0 || len(c.want) != 0 {
+				if !reflect.DeepEqual(deps, c.want) {
+					t.Errorf("dependencies = %v, want %v", deps, c.want)
+				}
+			}
+			if !reflect.DeepEqual(labels, c.labels) {
+				t.Errorf("pod labels = %v, want %v", labels, c.labels)
+			}
+		})
+	}
+}
+
+func TestServiceSelects(t *testing.T) {
+	podLabels := map[string]string{"app": "api", "tier": "backend"}
+	cases := []struct {
+		name string
+		svc  string
+		want bool
+	}{
+		{
+			name: "selector matches",
+			svc: `
+apiVersion: v1
+kind: Service
+metadata: {namespace: payments, name: api}
+spec:
+  selector: {app: api}
+`,
+			want: true,
+		},
+		{
+			name: "selector matches every label",
+			svc: `
+apiVersion: v1
+kind: Service
+metadata: {namespace: payments, name: api-backend}
+spec:
+  selector: {app: api, tier: backend}
+`,
+			want: true,
+		},
+		{
+			name: "selector does not match",
+			svc: `
+apiVersion: v1
+kind: Service
+metadata: {namespace: payments, name: web}
+spec:
+  selector: {app: web}
+`,
+		},
+		{
+			name: "selector has more labels",
+			svc: `
+apiVersion: v1
+kind: Service
+metadata: {namespace: payments, name: api-canary}
+spec:
+  selector: {app: api, track: canary}
+`,
+		},
+		{
+			name: "service without selector",
+			svc: `
+apiVersion: v1
+kind: Service
+metadata: {namespace: payments, name: external-db}
+spec:
+  type: ExternalName
+  externalName: db.example.com
+`,
+		},
+		{
+			name: "empty selector",
+			svc: `
+apiVersion: v1
+kind: Service
+metadata: {namespace: payments, name: empty}
+spec:
+  selector: {}
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ServiceSelects(decodeYAML(t, c.svc), podLabels); got != c.want {
+				t.Errorf("ServiceSelects() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package restore
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	secretKind = schema.GroupKind{Group: "", Kind: "Secret"}
+	crdKind    = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+)
+
+// crdEstablishTimeout is how long the restored CRDs may take to be served
+var crdEstablishTimeout = time.Minute
+
+// crdPollInterval is the delay between checks of a restored CRD
+var crdPollInterval = time.Second
+
+// bootstrapKinds are applied before anything else. Discovery is refreshed after
+// them, so that kinds defined by restored CRDs can be resolved.
+var bootstrapKinds = []schema.GroupKind{
+	crdKind,
+	{Group: "", Kind: "Namespace"},
+}
+
+// priorities lists the kinds that must exist before the workloads using them.
+// Kinds not listed here are applied afterwards in path order.
+var priorities = append(bootstrapKinds, []schema.GroupKind{
+	{Group: "storage.k8s.io", Kind: "StorageClass"},
+	{Group: "", Kind: "PersistentVolume"},
+	{Group: "", Kind: "PersistentVolumeClaim"},
+	{Group: "", Kind: "ServiceAccount"},
+	{Group: "", Kind: "Secret"},
+	{Group: "", Kind: "ConfigMap"},
+	{Group: "", Kind: "LimitRange"},
+	{Group: "", Kind: "ResourceQuota"},
+}...)
+
+type RestoreManager struct {
+	config            *rest.Config
+	secretsPrivateKey *ecdh.PrivateKey
+}
+
+type Options struct {
+	// SecretsPrivateKey decrypts the Secrets dumped with backup.SecretsEncrypt
+	SecretsPrivateKey *ecdh.PrivateKey
+}
+
+func NewRestoreManager(config *rest.Config, opt Options) RestoreManager {
+	return RestoreManager{
+		config:            config,
+		secretsPrivateKey: opt.SecretsPrivateKey,
+	}
+}
+
+// FindSnapshotDir returns the directory inside root that holds a dumped snapshot,
+// i.e. the directory containing resource_lists.yaml. It fails if root holds
+// more than one, as their names do not tell which one is the newest.
+func FindSnapshotDir(root string) (string, error) {
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == backup.ResourceListsFile {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(dirs) == 0 {
+		return "", os.ErrNotExist
+	} else if len(dirs) > 1 {
+		return "", fmt.Errorf("found %d dumped snapshots in %s: %s", len(dirs), root, strings.Join(dirs, ", "))
+	}
+	return dirs[0], nil
+}
+
+// LoadObjects reads every dumped object from snapshotDir. If the snapshot has
+// a manifest, the objects listed in it are read in manifest order and their
+// checksums are verified. Otherwise the snapshot is walked and objects are
+// returned sorted by their path relative to snapshotDir.
+func LoadObjects(snapshotDir string) ([]*unstructured.Unstructured, error) {
+	manifest, err := backup.ReadManifest(snapshotDir)
+	if err == nil {
+		return loadManifestObjects(snapshotDir, manifest)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var paths []string
+	err = filepath.Walk(snapshotDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".yaml") || filepath.Dir(path) == snapshotDir && isSnapshotFile(info.Name()) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	objects := make([]*unstructured.Unstructured, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := DecodeObject(data)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+func loadManifestObjects(snapshotDir string, manifest *backup.Manifest) ([]*unstructured.Unstructured, error) {
+	objects := make([]*unstructured.Unstructured, 0, len(manifest.Objects))
+	for _, entry := range manifest.Objects {
+		data, err := ioutil.ReadFile(filepath.Join(snapshotDir, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != entry.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s", entry.Path)
+		}
+		obj, err := DecodeObject(data)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// isSnapshotFile reports whether name is one of the files describing the
+// snapshot itself rather than a dumped object.
+func isSnapshotFile(name string) bool {
+	return name == backup.ResourceListsFile || name == backup.MetadataFile || name == backup.ManifestFile
+}
+
+// DecodeObject decodes a dumped object file
+func DecodeObject(data []byte) (*unstructured.Unstructured, error) {
+	js, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(js); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// RestoreFromDir applies every object dumped in snapshotDir to the cluster.
+// CRDs and Namespaces are applied first, followed by the other kinds listed in
+// priorities and then everything else, once the restored CRDs are established. Errors are collected so that one bad
+// object does not stop the rest of the restore.
+func (mgr RestoreManager) RestoreFromDir(snapshotDir string) error {
+	md, err := backup.ReadMetadata(snapshotDir)
+	if err != nil {
+		return err
+	}
+	if md.Partial {
+		glog.Warningf("Snapshot %s is a partial backup (label selector: %q, field selector: %q, filter: %+v), objects missing from it are left untouched",
+			snapshotDir, md.LabelSelector, md.FieldSelector, md.Filter)
+	}
+
+	objects, err := LoadObjects(snapshotDir)
+	if err != nil {
+		return err
+	}
+	return mgr.Restore(objects)
+}
+
+func (mgr RestoreManager) Restore(objects []*unstructured.Unstructured) error {
+	sortByPriority(objects)
+
+	// CRDs and Namespaces must be in place before the API server can serve the
+	// rest, so apply them first, wait for the CRDs to be established and
+	// refresh discovery afterwards.
+	var first, rest []*unstructured.Unstructured
+	for _, obj := range objects {
+		if priority(obj) < len(bootstrapKinds) {
+			first = append(first, obj)
+		} else {
+			rest = append(rest, obj)
+		}
+	}
+
+	var errs []error
+	// customKinds are the kinds defined by the restored CRDs
+	customKinds := map[schema.GroupKind]bool{}
+	var crds []*unstructured.Unstructured
+	for i, batch := range [][]*unstructured.Unstructured{first, rest} {
+		if len(batch) == 0 {
+			continue
+		}
+		if i == 1 && len(crds) > 0 {
+			errs = append(errs, mgr.waitForCRDs(crds)...)
+		}
+		applier, err := mgr.newApplier()
+		if err != nil {
+			return err
+		}
+		applier.customKinds = customKinds
+		for _, obj := range batch {
+			if obj.GroupVersionKind().GroupKind() == secretKind {
+				if backup.IsRedactedSecret(obj.Object) {
+					glog.Warningf("Skipping Secret %s/%s: its data was redacted during backup", obj.GetNamespace(), obj.GetName())
+					continue
+				}
+				if err := backup.DecryptSecret(obj.Object, mgr.secretsPrivateKey); err != nil {
+					errs = append(errs, errors.Wrapf(err, "failed to restore Secret %s/%s", obj.GetNamespace(), obj.GetName()))
+					continue
+				}
+			}
+			if err := applier.apply(obj); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if obj.GroupVersionKind().GroupKind() == crdKind {
+				crds = append(crds, obj)
+				group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+				kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+				customKinds[schema.GroupKind{Group: group, Kind: kind}] = true
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// waitForCRDs waits until the API server serves the restored CRDs, so that
+// their custom resources can be created. It returns an error for every CRD
+// that is not established within crdEstablishTimeout.
+func (mgr RestoreManager) waitForCRDs(crds []*unstructured.Unstructured) []error {
+	client, err := dynamic.NewForConfig(mgr.config)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	deadline := time.Now().Add(crdEstablishTimeout)
+	for _, crd := range crds {
+		rc := client.Resource(crd.GroupVersionKind().GroupVersion().WithResource("customresourcedefinitions"))
+		for {
+			cur, err := rc.Get(crd.GetName(), metav1.GetOptions{})
+			if err == nil && crdEstablished(cur) {
+				break
+			}
+			if time.Now().After(deadline) {
+				if err == nil {
+					err = errors.New("condition Established is not True")
+				}
+				errs = append(errs, errors.Wrapf(err, "CustomResourceDefinition %s is not established after %v", crd.GetName(), crdEstablishTimeout))
+				break
+			}
+			glog.V(3).Infof("Waiting for CustomResourceDefinition %s to be established", crd.GetName())
+			time.Sleep(crdPollInterval)
+		}
+	}
+	return errs
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if ok && cond["type"] == "Established" && cond["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+func priority(obj *unstructured.Unstructured) int {
+	gk := obj.GroupVersionKind().GroupKind()
+	for i, p := range priorities {
+		if p == gk {
+			return i
+		}
+	}
+	return len(priorities)
+}
+
+func sortByPriority(objects []*unstructured.Unstructured) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		return priority(objects[i]) < priority(objects[j])
+	})
+}
+
+type applier struct {
+	client    dynamic.Interface
+	resources map[schema.GroupVersionKind]metav1.APIResource
+	// customKinds are the kinds of the restored CRDs, their objects must be served
+	customKinds map[schema.GroupKind]bool
+}
+
+func (mgr RestoreManager) newApplier() (*applier, error) {
+	disClient, err := discovery.NewDiscoveryClientForConfig(mgr.config)
+	if err != nil {
+		return nil, err
+	}
+	resourceLists, err := disClient.ServerResources()
+	if err != nil && len(resourceLists) == 0 {
+		return nil, err
+	}
+
+	resources := make(map[schema.GroupVersionKind]metav1.APIResource)
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range list.APIResources {
+			if strings.ContainsRune(r.Name, '/') {
+				continue // skip subresource
+			}
+			resources[gv.WithKind(r.Kind)] = r
+		}
+	}
+
+	client, err := dynamic.NewForConfig(mgr.config)
+	if err != nil {
+		return nil, err
+	}
+	return &applier{client: client, resources: resources}, nil
+}
+
+func (a *applier) apply(obj *unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+	r, ok := a.resources[gvk]
+	if !ok && a.customKinds[gvk.GroupKind()] {
+		return fmt.Errorf("failed to restore %s %s/%s: kind is not served by the cluster", gvk, obj.GetNamespace(), obj.GetName())
+	} else if !ok {
+		glog.Warningf("Skipping %s %s/%s: kind is not served by the cluster", gvk, obj.GetNamespace(), obj.GetName())
+		return nil
+	}
+	if !sets.NewString(r.Verbs...).Has("create") {
+		glog.V(3).Infof("Skipping %s %s/%s: resource does not support create", gvk, obj.GetNamespace(), obj.GetName())
+		return nil
+	}
+
+	prepareForCreate(obj)
+
+	var client dynamic.ResourceInterface = a.client.Resource(gvk.GroupVersion().WithResource(r.Name))
+	if r.Namespaced {
+		client = a.client.Resource(gvk.GroupVersion().WithResource(r.Name)).Namespace(obj.GetNamespace())
+	}
+
+	glog.V(3).Infof("Restoring %s %s/%s", gvk, obj.GetNamespace(), obj.GetName())
+	_, err := client.Create(obj, metav1.CreateOptions{})
+	if kerr.IsAlreadyExists(err) {
+		var cur *unstructured.Unstructured
+		cur, err = client.Get(obj.GetName(), metav1.GetOptions{})
+		if err == nil {
+			obj.SetResourceVersion(cur.GetResourceVersion())
+			_, err = client.Update(obj, metav1.UpdateOptions{})
+		}
+	}
+	return errors.Wrapf(err, "failed to restore %s %s/%s", gvk, obj.GetNamespace(), obj.GetName())
+}
+
+// prepareForCreate drops the server populated fields that the API server
+// rejects on create, in case the snapshot was taken without sanitization.
+func prepareForCreate(obj *unstructured.Unstructured) {
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	obj.SetSelfLink("")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(obj.Object, "status")
+}
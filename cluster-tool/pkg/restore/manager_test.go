@@ -0,0 +1,138 @@
+package restore
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appscodelabs/actions/cluster-tool/pkg/backup"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+func TestFindSnapshotDir(t *testing.T) {
+	cases := []struct {
+		name    string
+		dumps   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "no dump", wantErr: true},
+		{name: "one dump", dumps: []string{"tmp/restic/backup/prod-2021-03-01T09-00-00"}, want: "tmp/restic/backup/prod-2021-03-01T09-00-00"},
+		{name: "two dumps", dumps: []string{"backup/prod-2021-03-01T09-00-00", "backup/staging-2021-03-02T09-00-00"}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "snapshot-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			for _, d := range c.dumps {
+				if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(root, d, backup.ResourceListsFile), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := FindSnapshotDir(root)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("FindSnapshotDir() = %s, expected an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, c.want); got != want {
+				t.Errorf("FindSnapshotDir() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func crd(name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apiextensions.k8s.io/v1beta1")
+	obj.SetKind("CustomResourceDefinition")
+	obj.SetName(name)
+	return obj
+}
+
+func TestWaitForCRDs(t *testing.T) {
+	crdPollInterval = time.Millisecond
+	defer func() { crdPollInterval = time.Second }()
+
+	var mu sync.Mutex
+	gets := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(r.URL.Path)
+		mu.Lock()
+		gets[name]++
+		n := gets[name]
+		mu.Unlock()
+
+		status := "False"
+		// backups.example.com is established on the third check, broken.example.com never
+		if name == "backups.example.com" && n >= 3 {
+			status = "True"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"apiVersion":"apiextensions.k8s.io/v1beta1","kind":"CustomResourceDefinition","metadata":{"name":"` + name + `"},
+			"status":{"conditions":[{"type":"NamesAccepted","status":"True"},{"type":"Established","status":"` + status + `"}]}}`))
+	}))
+	defer srv.Close()
+
+	mgr := NewRestoreManager(&rest.Config{Host: srv.URL}, Options{})
+
+	crdEstablishTimeout = time.Second
+	defer func() { crdEstablishTimeout = time.Minute }()
+	if errs := mgr.waitForCRDs([]*unstructured.Unstructured{crd("backups.example.com")}); len(errs) > 0 {
+		t.Errorf("waitForCRDs() = %v", errs)
+	}
+	mu.Lock()
+	if gets["backups.example.com"] != 3 {
+		t.Errorf("checked the CRD %d times, want 3", gets["backups.example.com"])
+	}
+	mu.Unlock()
+
+	crdEstablishTimeout = 20 * time.Millisecond
+	errs := mgr.waitForCRDs([]*unstructured.Unstructured{crd("broken.example.com")})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "broken.example.com is not established") {
+		t.Errorf("waitForCRDs() = %v, want an error for broken.example.com", errs)
+	}
+}
+
+func TestApplyUnservedKind(t *testing.T) {
+	backupKind := schema.GroupKind{Group: "example.com", Kind: "Backup"}
+	a := &applier{
+		resources:   map[schema.GroupVersionKind]metav1.APIResource{},
+		customKinds: map[schema.GroupKind]bool{backupKind: true},
+	}
+
+	custom := &unstructured.Unstructured{}
+	custom.SetAPIVersion("example.com/v1")
+	custom.SetKind("Backup")
+	custom.SetName("nightly")
+	if err := a.apply(custom); err == nil {
+		t.Error("a custom resource of a restored CRD that is not served was skipped")
+	}
+
+	other := &unstructured.Unstructured{}
+	other.SetAPIVersion("metrics.k8s.io/v1beta1")
+	other.SetKind("PodMetrics")
+	other.SetName("api")
+	if err := a.apply(other); err != nil {
+		t.Errorf("apply() = %v, a kind not served by the cluster is skipped", err)
+	}
+}
@@ -0,0 +1,162 @@
+package restore
+
+import (
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	serviceAccountUserPrefix  = "system:serviceaccount:"
+	serviceAccountGroupPrefix = "system:serviceaccounts:"
+	namespaceNameLabel        = "kubernetes.io/metadata.name"
+)
+
+// hostName matches the host names in env values, i.e. "api.prod" in "http://api.prod:8080"
+var hostName = regexp.MustCompile(`[A-Za-z0-9][A-Za-z0-9.-]*`)
+
+// NamespaceMapping maps the namespaces of objects to the namespaces they are
+// created in. Namespaces missing from the mapping are kept as is.
+type NamespaceMapping struct {
+	namespaces    map[string]string
+	clusterDomain string
+	// services are the mapped Services as <service>.<namespace>
+	services sets.String
+}
+
+// NewNamespaceMapping returns the mapping of namespaces for objects, the objects
+// are not modified. The Services among them are the ones whose short DNS names
+// (<service>.<namespace>) are rewritten in env values.
+func NewNamespaceMapping(namespaces map[string]string, clusterDomain string, objects []*unstructured.Unstructured) NamespaceMapping {
+	m := NamespaceMapping{
+		namespaces:    namespaces,
+		clusterDomain: clusterDomain,
+		services:      sets.NewString(),
+	}
+	for _, obj := range objects {
+		if obj.GetAPIVersion() == "v1" && obj.GetKind() == "Service" {
+			m.services.Insert(obj.GetName() + "." + obj.GetNamespace())
+		}
+	}
+	return m
+}
+
+// Map returns the namespace ns is mapped to
+func (m NamespaceMapping) Map(ns string) string {
+	if to, ok := m.namespaces[ns]; ok {
+		return to
+	}
+	return ns
+}
+
+// Apply moves obj to its mapped namespace in place. Namespace references inside
+// the object are rewritten as well: the subjects of RoleBindings, including
+// ServiceAccount user and group names, and the Service DNS names in the env
+// values of pod templates.
+func (m NamespaceMapping) Apply(obj *unstructured.Unstructured) error {
+	if ns := obj.GetNamespace(); ns != "" {
+		obj.SetNamespace(m.Map(ns))
+	}
+
+	switch {
+	case obj.GetAPIVersion() == "v1" && obj.GetKind() == "Namespace":
+		name := m.Map(obj.GetName())
+		obj.SetName(name)
+		if labels := obj.GetLabels(); labels[namespaceNameLabel] != "" {
+			labels[namespaceNameLabel] = name
+			obj.SetLabels(labels)
+		}
+	case strings.HasPrefix(obj.GetAPIVersion(), "rbac.authorization.k8s.io/") && obj.GetKind() == "RoleBinding":
+		subjects, _, err := unstructured.NestedSlice(obj.Object, "subjects")
+		if err != nil {
+			return err
+		}
+		for _, s := range subjects {
+			if subject, ok := s.(map[string]interface{}); ok {
+				m.mapSubject(subject)
+			}
+		}
+		if subjects != nil {
+			return unstructured.SetNestedSlice(obj.Object, subjects, "subjects")
+		}
+	}
+
+	template, ok := podTemplate(obj)
+	if !ok {
+		return nil
+	}
+	spec, ok := template["spec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := spec[field].([]interface{})
+		for _, c := range containers {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			env, _ := container["env"].([]interface{})
+			for _, e := range env {
+				if v, ok := e.(map[string]interface{}); ok {
+					if value, ok := v["value"].(string); ok {
+						v["value"] = m.mapHostNames(value)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (m NamespaceMapping) mapSubject(subject map[string]interface{}) {
+	if ns, ok := subject["namespace"].(string); ok && ns != "" {
+		subject["namespace"] = m.Map(ns)
+	}
+	name, _ := subject["name"].(string)
+	switch subject["kind"] {
+	case "User":
+		// system:serviceaccount:<namespace>:<name>
+		if parts := strings.Split(name, ":"); len(parts) == 4 && strings.HasPrefix(name, serviceAccountUserPrefix) {
+			subject["name"] = serviceAccountUserPrefix + m.Map(parts[2]) + ":" + parts[3]
+		}
+	case "Group":
+		// system:serviceaccounts:<namespace>
+		if strings.HasPrefix(name, serviceAccountGroupPrefix) {
+			subject["name"] = serviceAccountGroupPrefix + m.Map(strings.TrimPrefix(name, serviceAccountGroupPrefix))
+		}
+	}
+}
+
+// mapHostNames rewrites the namespace of the Service DNS names in s. Names either
+// end in the svc label, optionally followed by the cluster domain (i.e.
+// <service>.<namespace>.svc.cluster.local or <pod>.<service>.<namespace>.svc),
+// or are <service>.<namespace> for a mapped Service. Other names are kept, as
+// <name>.<namespace> may as well be a host outside the cluster.
+func (m NamespaceMapping) mapHostNames(s string) string {
+	return hostName.ReplaceAllStringFunc(s, func(token string) string {
+		host := strings.TrimRight(token, ".")
+		name := host
+		if m.clusterDomain != "" {
+			name = strings.TrimSuffix(name, "."+m.clusterDomain)
+		}
+		labels := strings.Split(name, ".")
+		var i int
+		switch {
+		case len(labels) >= 3 && labels[len(labels)-1] == "svc":
+			i = len(labels) - 2
+		case name == host && len(labels) == 2 && m.services.Has(name):
+			i = 1
+		default:
+			return token
+		}
+		to, ok := m.namespaces[labels[i]]
+		if !ok {
+			return token
+		}
+		labels[i] = to
+		return strings.Join(labels, ".") + strings.TrimPrefix(token, name)
+	})
+}
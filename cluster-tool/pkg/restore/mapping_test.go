@@ -0,0 +1,270 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func decodeYAML(t *testing.T, s string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(s), &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+var mappedServices = []*unstructured.Unstructured{
+	{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Service", "metadata": map[string]interface{}{"namespace": "prod", "name": "api"}}},
+	{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Service", "metadata": map[string]interface{}{"namespace": "shared", "name": "db"}}},
+}
+
+func TestNamespaceMappingApply(t *testing.T) {
+	cases := []struct {
+		name string
+		obj  string
+		want string
+	}{
+		{
+			name: "namespaced object",
+			obj: `
+apiVersion: v1
+kind: ConfigMap
+metadata: {namespace: prod, name: config}
+`,
+			want: `
+apiVersion: v1
+kind: ConfigMap
+metadata: {namespace: staging, name: config}
+`,
+		},
+		{
+			name: "unmapped namespace",
+			obj: `
+apiVersion: v1
+kind: ConfigMap
+metadata: {namespace: shared, name: config}
+`,
+			want: `
+apiVersion: v1
+kind: ConfigMap
+metadata: {namespace: shared, name: config}
+`,
+		},
+		{
+			name: "namespace",
+			obj: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: prod
+  labels: {kubernetes.io/metadata.name: prod, team: a}
+`,
+			want: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: staging
+  labels: {kubernetes.io/metadata.name: staging, team: a}
+`,
+		},
+		{
+			name: "role binding subjects",
+			obj: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata: {namespace: prod, name: readers}
+roleRef: {apiGroup: rbac.authorization.k8s.io, kind: Role, name: reader}
+subjects:
+- {kind: ServiceAccount, namespace: prod, name: api}
+- {kind: ServiceAccount, namespace: shared, name: monitor}
+- {apiGroup: rbac.authorization.k8s.io, kind: User, name: "system:serviceaccount:prod:worker"}
+- {apiGroup: rbac.authorization.k8s.io, kind: User, name: "system:serviceaccount:shared:worker"}
+- {apiGroup: rbac.authorization.k8s.io, kind: User, name: "prod"}
+- {apiGroup: rbac.authorization.k8s.io, kind: Group, name: "system:serviceaccounts:prod"}
+- {apiGroup: rbac.authorization.k8s.io, kind: Group, name: "system:serviceaccounts"}
+- {apiGroup: rbac.authorization.k8s.io, kind: Group, name: "prod"}
+`,
+			want: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata: {namespace: staging, name: readers}
+roleRef: {apiGroup: rbac.authorization.k8s.io, kind: Role, name: reader}
+subjects:
+- {kind: ServiceAccount, namespace: staging, name: api}
+- {kind: ServiceAccount, namespace: shared, name: monitor}
+- {apiGroup: rbac.authorization.k8s.io, kind: User, name: "system:serviceaccount:staging:worker"}
+- {apiGroup: rbac.authorization.k8s.io, kind: User, name: "system:serviceaccount:shared:worker"}
+- {apiGroup: rbac.authorization.k8s.io, kind: User, name: "prod"}
+- {apiGroup: rbac.authorization.k8s.io, kind: Group, name: "system:serviceaccounts:staging"}
+- {apiGroup: rbac.authorization.k8s.io, kind: Group, name: "system:serviceaccounts"}
+- {apiGroup: rbac.authorization.k8s.io, kind: Group, name: "prod"}
+`,
+		},
+		{
+			name: "role binding without subjects",
+			obj: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata: {namespace: prod, name: empty}
+roleRef: {apiGroup: rbac.authorization.k8s.io, kind: Role, name: reader}
+`,
+			want: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata: {namespace: staging, name: empty}
+roleRef: {apiGroup: rbac.authorization.k8s.io, kind: Role, name: reader}
+`,
+		},
+		{
+			name: "env values of a pod template",
+			obj: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {namespace: prod, name: web}
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: wait
+        env:
+        - {name: API, value: "api.prod:8080"}
+      containers:
+      - name: web
+        env:
+        - {name: API_URL, value: "http://api.prod.svc.cluster.local:8080/v1"}
+        - {name: API_SVC, value: "api.prod.svc"}
+        - {name: API_FQDN, value: "api.prod.svc.cluster.local."}
+        - {name: POD, value: "web-0.web.prod.svc.cluster.local"}
+        - {name: PEERS, value: "api.prod,db.shared.svc"}
+        - {name: SECRET, valueFrom: {secretKeyRef: {name: web, key: token}}}
+`,
+			want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {namespace: staging, name: web}
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: wait
+        env:
+        - {name: API, value: "api.staging:8080"}
+      containers:
+      - name: web
+        env:
+        - {name: API_URL, value: "http://api.staging.svc.cluster.local:8080/v1"}
+        - {name: API_SVC, value: "api.staging.svc"}
+        - {name: API_FQDN, value: "api.staging.svc.cluster.local."}
+        - {name: POD, value: "web-0.web.staging.svc.cluster.local"}
+        - {name: PEERS, value: "api.staging,db.shared.svc"}
+        - {name: SECRET, valueFrom: {secretKeyRef: {name: web, key: token}}}
+`,
+		},
+		{
+			name: "env values that are not Service DNS names",
+			obj: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {namespace: prod, name: web}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        env:
+        - {name: OTHER_SERVICE, value: "web.prod:8080"}
+        - {name: EXTERNAL, value: "https://www.prod/index.html"}
+        - {name: FILE, value: "config.prod"}
+        - {name: HOST, value: "api.prod.example.com"}
+        - {name: OTHER_DOMAIN, value: "api.prod.svc.example.org"}
+        - {name: CLUSTER_DOMAIN, value: "api.prod.cluster.local"}
+        - {name: NAMESPACE, value: "prod"}
+`,
+			want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {namespace: staging, name: web}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        env:
+        - {name: OTHER_SERVICE, value: "web.prod:8080"}
+        - {name: EXTERNAL, value: "https://www.prod/index.html"}
+        - {name: FILE, value: "config.prod"}
+        - {name: HOST, value: "api.prod.example.com"}
+        - {name: OTHER_DOMAIN, value: "api.prod.svc.example.org"}
+        - {name: CLUSTER_DOMAIN, value: "api.prod.cluster.local"}
+        - {name: NAMESPACE, value: "prod"}
+`,
+		},
+		{
+			name: "env values of a pod",
+			obj: `
+apiVersion: v1
+kind: Pod
+metadata: {namespace: prod, name: job}
+spec:
+  containers:
+  - name: job
+    env:
+    - {name: API, value: "api.prod.svc.cluster.local"}
+`,
+			want: `
+apiVersion: v1
+kind: Pod
+metadata: {namespace: staging, name: job}
+spec:
+  containers:
+  - name: job
+    env:
+    - {name: API, value: "api.staging.svc.cluster.local"}
+`,
+		},
+	}
+
+	m := NewNamespaceMapping(map[string]string{"prod": "staging"}, "cluster.local", mappedServices)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			obj := decodeYAML(t, c.obj)
+			if err := m.Apply(obj); err != nil {
+				t.Fatal(err)
+			}
+			if want := decodeYAML(t, c.want); !reflect.DeepEqual(obj.Object, want.Object) {
+				got, _ := yaml.Marshal(obj.Object)
+				t.Errorf("got\n%s\nwant%s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceMappingMapHostNames(t *testing.T) {
+	cases := []struct {
+		name          string
+		clusterDomain string
+		value         string
+		want          string
+	}{
+		{name: "cluster domain", clusterDomain: "cluster.local", value: "api.prod.svc.cluster.local", want: "api.staging.svc.cluster.local"},
+		{name: "custom cluster domain", clusterDomain: "example.internal", value: "api.prod.svc.example.internal", want: "api.staging.svc.example.internal"},
+		{name: "default domain with a custom cluster domain", clusterDomain: "example.internal", value: "api.prod.svc.cluster.local", want: "api.prod.svc.cluster.local"},
+		{name: "no cluster domain", value: "api.prod.svc", want: "api.staging.svc"},
+		{name: "mapped service", value: "postgres://api.prod:5432/db", want: "postgres://api.staging:5432/db"},
+		{name: "service of an unmapped namespace", value: "db.shared", want: "db.shared"},
+		{name: "unknown service", value: "cache.prod", want: "cache.prod"},
+		{name: "svc label alone", value: "prod.svc", want: "prod.svc"},
+		{name: "several names", value: "api.prod api.prod.svc cache.prod", want: "api.staging api.staging.svc cache.prod"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewNamespaceMapping(map[string]string{"prod": "staging"}, c.clusterDomain, mappedServices)
+			if got := m.mapHostNames(c.value); got != c.want {
+				t.Errorf("mapHostNames(%q) = %q, want %q", c.value, got, c.want)
+			}
+		})
+	}
+}